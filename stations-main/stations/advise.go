@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// improvement is one change to the network the advisor tried: a new track
+// when Add is true, otherwise the removal of an existing one.
+type improvement struct {
+	A, B  string
+	Add   bool
+	Turns int   // turns for the trains with the change made
+	Cut   bool  // the removal leaves no way from start to end
+	Err   error // the change could not be made or planned, say in time
+}
+
+func (c improvement) String() string {
+	if c.Add {
+		return "+ " + trackKey(c.A, c.B)
+	}
+	return "- " + trackKey(c.A, c.B)
+}
+
+// advice is what the advisor found for one start, end and train count.
+type advice struct {
+	Turns          int // turns on the network as it is
+	Within         float64
+	Added, Removed int           // new tracks and removals tried
+	Improvements   []improvement // changes that save turns, most saved first
+	Costs          []improvement // changes that add turns, fewest added first
+	Unchanged      int           // changes that neither save nor add turns
+	Cuts           int           // removals after which the trains cannot get through
+	Failed         []improvement // changes that could not be made or planned
+}
+
+// longestTrack is the length of the longest track, the default reach of new
+// tracks.
+func longestTrack(network *Network) float64 {
+	longest := 0
+	for a, neighbors := range network.Connections {
+		for _, b := range neighbors {
+			longest = max(longest, distance(network.Stations[a], network.Stations[b]))
+		}
+	}
+	return float64(longest)
+}
+
+// candidateTracks lists the station pairs at most within apart that have no
+// track between them, each pair once and sorted. Geo distances are in metres.
+func candidateTracks(network *Network, within float64) [][2]string {
+	idx := newSpatialIndex(network.Stations)
+	var pairs [][2]string
+	for _, a := range network.StationNames() {
+		st := network.Stations[a]
+		var near []Station
+		if st.Geo {
+			near = idx.InMetres(st.Lat, st.Lon, within)
+		} else {
+			near = idx.InRadius(float64(st.X), float64(st.Y), within)
+		}
+		for _, other := range near {
+			b := other.Name
+			if a < b && !contains(network.Connections[a], b) && !network.Closed[trackKey(a, b)] {
+				pairs = append(pairs, [2]string{a, b})
+			}
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool { return trackKey(pairs[i][0], pairs[i][1]) < trackKey(pairs[j][0], pairs[j][1]) })
+	return pairs
+}
+
+// turnsFor plans and simulates the trains on a network and returns the turns
+// it took. timeout limits the route search when it is not 0.
+func turnsFor(network *Network, start, end string, traincount int, timeout time.Duration) (int, error) {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	paths, _, err := planRoutes(ctx, network.Stations, network.Connections, start, end, traincount, nil)
+	if err != nil {
+		return 0, err
+	}
+	return len(simulate(Trainnames(traincount, start), paths, network.Stations, start, nil)), nil
+}
+
+// advise tries every new track up to within apart and the removal of every
+// track, re-planning the trains for each on its own copy of the network.
+// The changes are tried in parallel, one worker per CPU.
+func advise(network *Network, start, end string, traincount int, within float64, timeout time.Duration) (*advice, error) {
+	turns, err := turnsFor(network, start, end, traincount, timeout)
+	if err != nil {
+		return nil, err
+	}
+	var changes []improvement
+	for _, pair := range candidateTracks(network, within) {
+		changes = append(changes, improvement{A: pair[0], B: pair[1], Add: true})
+	}
+	for _, track := range network.Tracks() {
+		a, b, _ := cutTrack(track, network.Stations)
+		changes = append(changes, improvement{A: a, B: b})
+	}
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < runtime.NumCPU(); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				c := &changes[i]
+				changed := newNetwork(network.Stations, network.Connections)
+				if c.Add {
+					c.Err = changed.Connect(c.A, c.B)
+				} else {
+					c.Err = changed.Close(c.A, c.B)
+					c.Cut = c.Err == nil && !reachable(changed.Stations, changed.Connections, start, end)
+				}
+				if c.Err == nil && !c.Cut {
+					c.Turns, c.Err = turnsFor(changed, start, end, traincount, timeout)
+				}
+			}
+		}()
+	}
+	for i := range changes {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	result := &advice{Turns: turns, Within: within}
+	for _, c := range changes {
+		if c.Add {
+			result.Added++
+		} else {
+			result.Removed++
+		}
+		switch {
+		case c.Cut:
+			result.Cuts++
+		case c.Err != nil:
+			result.Failed = append(result.Failed, c)
+		case c.Turns < turns:
+			result.Improvements = append(result.Improvements, c)
+		case c.Turns > turns:
+			result.Costs = append(result.Costs, c)
+		default:
+			result.Unchanged++
+		}
+	}
+	byTurns := func(changes []improvement) {
+		sort.SliceStable(changes, func(i, j int) bool { return changes[i].Turns < changes[j].Turns })
+	}
+	byTurns(result.Improvements)
+	byTurns(result.Costs)
+	return result, nil
+}
+
+// Write prints the changes that save turns and, in a section of their own,
+// those that add turns, top of each at most.
+func (a *advice) Write(w io.Writer, top int) {
+	fmt.Fprintf(w, "Tried %d new track(s) up to %g apart and %d removal(s)\n", a.Added, a.Within, a.Removed)
+	fmt.Fprintf(w, "Turns as the network is: %d\n", a.Turns)
+	if len(a.Improvements) == 0 {
+		fmt.Fprintln(w, "No change saves turns")
+	} else {
+		fmt.Fprintf(w, "%4s  %-41s %6s %6s\n", "Rank", "Change", "Turns", "Saved")
+	}
+	for i, c := range a.Improvements {
+		if i == top {
+			break
+		}
+		fmt.Fprintf(w, "%4d  %-41s %6d %6d\n", i+1, c.String(), c.Turns, a.Turns-c.Turns)
+	}
+	if len(a.Costs) > 0 {
+		fmt.Fprintln(w, "Changes that add turns:")
+		fmt.Fprintf(w, "%4s  %-41s %6s %6s\n", "Rank", "Change", "Turns", "Added")
+	}
+	for i, c := range a.Costs {
+		if i == top {
+			break
+		}
+		fmt.Fprintf(w, "%4d  %-41s %6d %6d\n", i+1, c.String(), c.Turns, c.Turns-a.Turns)
+	}
+	if a.Unchanged > 0 {
+		fmt.Fprintf(w, "%d change(s) make no difference\n", a.Unchanged)
+	}
+	if a.Cuts > 0 {
+		fmt.Fprintf(w, "%d removal(s) would leave no way from start to end\n", a.Cuts)
+	}
+	for _, c := range a.Failed {
+		fmt.Fprintf(w, "%s could not be tried: %s\n", c, c.Err)
+	}
+}
+
+func adviseMain(args []string, opts *options) {
+	if len(args) != 4 {
+		fmt.Fprintf(os.Stderr, "Error: incorrect number of arguments for advise\n")
+		fmt.Println(Green, " To find the changes that help the trains most:")
+		fmt.Println("  go run . advise [--within <distance>] [--top <changes>] <path to file containing network map> <start station> <end station> <numeric amount of trains>", Reset)
+		os.Exit(0)
+	}
+	network, err := loadNetwork(args[0], opts.parse)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Println(Red, "Please fix listed errors in", args[0], Reset)
+		os.Exit(0)
+	}
+	start, end := args[1], args[2]
+	traincount, err := strconv.Atoi(args[3])
+	if err != nil || traincount < 1 {
+		fmt.Fprintf(os.Stderr, "Error: unable to convert train numbers(%s) to a positive integer\n", args[3])
+		os.Exit(0)
+	}
+	if err := checkEndpoints(network, start, end); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		os.Exit(0)
+	}
+	within := opts.within
+	if within == 0 {
+		within = longestTrack(network)
+	}
+	result, err := advise(network, start, end, traincount, within, opts.timeout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		os.Exit(0)
+	}
+	result.Write(os.Stdout, opts.top)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// adviseMap routes every train from s to e round a four-track detour, while
+// s and e themselves lie only 4 apart. A direct s-e track lets a train
+// through every turn; no other single track does as well.
+const adviseMap = `stations:
+s,0,0
+m1,0,3
+m2,2,4
+m3,4,3
+e,4,0
+connections:
+s-m1
+m1-m2
+m2-m3
+m3-e
+`
+
+func TestAdviseNamesTheTrackThatSavesMostTurns(t *testing.T) {
+	data := parseMap(strings.NewReader(adviseMap), ParseConfig{})
+	if len(data.Errors) > 0 {
+		t.Fatal(data.Errors)
+	}
+	result, err := advise(newNetwork(data.Stations, data.Connections), "s", "e", 4, 5, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Turns != 7 {
+		t.Fatalf("4 trains along the detour should take 7 turns, took %d", result.Turns)
+	}
+	if len(result.Improvements) == 0 {
+		t.Fatal("no improvements advised")
+	}
+	best := result.Improvements[0]
+	if best.String() != "+ e-s" || best.Turns != 4 {
+		t.Fatalf("best advice should be + e-s in 4 turns, got %s in %d", best, best.Turns)
+	}
+	for _, c := range result.Improvements[1:] {
+		if c.Turns <= best.Turns || c.Turns >= result.Turns {
+			t.Errorf("%s should save fewer turns than %s, takes %d turns", c, best, c.Turns)
+		}
+	}
+	// Every existing track is the only way through, so no removal is advised.
+	if result.Cuts != 4 || len(result.Costs) != 0 || len(result.Failed) != 0 {
+		t.Errorf("expected all 4 removals to cut the network, got %d cuts, costs %v, failures %v", result.Cuts, result.Costs, result.Failed)
+	}
+}
+
+// On two parallel routes every removal leaves the trains one route, which
+// costs turns but does not cut the network.
+func TestAdviseListsChangesThatAddTurnsApart(t *testing.T) {
+	data := parseMap(strings.NewReader("stations:\ns,0,1\na,1,2\nb,1,0\ne,2,1\nconnections:\ns-a\na-e\ns-b\nb-e\n"), ParseConfig{})
+	result, err := advise(newNetwork(data.Stations, data.Connections), "s", "e", 4, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Improvements) != 0 || len(result.Costs) != 4 || result.Cuts != 0 || result.Removed != 4 {
+		t.Fatalf("expected 4 removals that add turns, got %+v", result)
+	}
+	var out strings.Builder
+	result.Write(&out, 10)
+	text := out.String()
+	if !strings.Contains(text, "No change saves turns\nChanges that add turns:\n") || strings.Contains(text, "no way from start to end") {
+		t.Fatalf("advice printed\n%s", text)
+	}
+	if !strings.Contains(text, "   1  - a-e                                          5      2\n") {
+		t.Fatalf("removing a-e should add 2 turns:\n%s", text)
+	}
+}
+
+func TestAdviceReportsFailuresApartFromCuts(t *testing.T) {
+	a := &advice{Turns: 3, Removed: 2, Cuts: 1, Failed: []improvement{{A: "a", B: "b", Add: true, Err: errors.New("no valid path between s and e")}}}
+	var out strings.Builder
+	a.Write(&out, 10)
+	text := out.String()
+	if !strings.Contains(text, "1 removal(s) would leave no way from start to end\n") ||
+		!strings.Contains(text, "+ a-b could not be tried: no valid path between s and e\n") {
+		t.Fatalf("advice printed\n%s", text)
+	}
+}
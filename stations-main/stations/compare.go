@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// plannerRun is how one planner did on one map.
+type plannerRun struct {
+	Planner string
+	Turns   int // 0 when the planner found no routes
+	Routes  int
+	Time    time.Duration
+	Memory  uint64 // bytes allocated while planning
+}
+
+// farthestPair picks the two stations furthest apart in tracks, to give every
+// map of a directory a run that crosses the whole network. It returns false
+// when no two stations are connected.
+func farthestPair(stations map[string]Station, connections map[string][]string) (string, string, bool) {
+	farthest := func(from string) string {
+		hops := map[string]int{from: 0}
+		queue := []string{from}
+		best := from
+		for len(queue) > 0 {
+			at := queue[0]
+			queue = queue[1:]
+			if hops[at] > hops[best] || hops[at] == hops[best] && at < best {
+				best = at
+			}
+			for _, next := range connections[at] {
+				if _, seen := hops[next]; !seen {
+					hops[next] = hops[at] + 1
+					queue = append(queue, next)
+				}
+			}
+		}
+		return best
+	}
+	names := make([]string, 0, len(stations))
+	for name := range stations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if len(connections[name]) == 0 {
+			continue
+		}
+		start := farthest(name)
+		end := farthest(start)
+		return start, end, start != end
+	}
+	return "", "", false
+}
+
+// runPlanner plans with one planner alone and simulates the routes it liked
+// best, measuring the time and memory planning took.
+func runPlanner(p Planner, stations map[string]Station, connections map[string][]string, start, end string, traincount int, timeout time.Duration) plannerRun {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	var paths [][]string
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	began := time.Now()
+	p.Plan(ctx, stations, connections, start, end, traincount, func(routes [][]string) { paths = routes }, nil)
+	run := plannerRun{Planner: p.Name(), Time: time.Since(began), Routes: len(paths)}
+	runtime.ReadMemStats(&after)
+	run.Memory = after.TotalAlloc - before.TotalAlloc
+	if len(paths) > 0 {
+		run.Turns = len(simulate(Trainnames(traincount, start), paths, stations, start, nil))
+	}
+	return run
+}
+
+func formatBytes(n uint64) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", n)
+}
+
+// compareMaps runs every planner on every map file of a directory and prints
+// a row per planner and map. Maps with errors are listed as skipped.
+func compareMaps(w io.Writer, dir string, traincount int, timeout time.Duration, cfg ParseConfig) error {
+	files, err := filepath.Glob(filepath.Join(dir, "*.txt"))
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no map files (*.txt) in %s", dir)
+	}
+	fmt.Fprintf(w, "%-20s %-36s %-8s %6s %6s %12s %10s\n", "Map", "Run", "Planner", "Turns", "Routes", "Time", "Memory")
+	for _, file := range files {
+		name := filepath.Base(file)
+		network, err := loadNetwork(file, cfg)
+		if err != nil {
+			fmt.Fprintf(w, "%-20s skipped, the map has errors\n", name)
+			continue
+		}
+		start, end, ok := farthestPair(network.Stations, network.Connections)
+		if !ok {
+			fmt.Fprintf(w, "%-20s skipped, no two stations are connected\n", name)
+			continue
+		}
+		run := start + " -> " + end
+		for _, p := range planners {
+			r := runPlanner(p, network.Stations, network.Connections, start, end, traincount, timeout)
+			turns := strconv.Itoa(r.Turns)
+			if r.Routes == 0 {
+				turns = "-"
+			}
+			fmt.Fprintf(w, "%-20s %-36s %-8s %6s %6d %12s %10s\n", name, run, r.Planner, turns, r.Routes, r.Time.Round(time.Microsecond), formatBytes(r.Memory))
+			name, run = "", ""
+		}
+	}
+	return nil
+}
+
+func compareMain(args []string, opts *options) {
+	if len(args) != 2 {
+		fmt.Fprintf(os.Stderr, "Error: incorrect number of arguments for compare\n")
+		fmt.Println(Green, " To compare the planners:")
+		fmt.Println("  go run . compare [--timeout <duration>] <directory of map files> <numeric amount of trains>", Reset)
+		os.Exit(0)
+	}
+	traincount, err := strconv.Atoi(args[1])
+	if err != nil || traincount < 1 {
+		fmt.Fprintf(os.Stderr, "Error: unable to convert train numbers(%s) to a positive integer\n", args[1])
+		os.Exit(0)
+	}
+	if err := compareMaps(os.Stdout, args[0], traincount, opts.timeout, opts.parse); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		os.Exit(0)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFarthestPair(t *testing.T) {
+	for _, c := range []struct {
+		name, text string
+		start, end string
+		ok         bool
+	}{
+		{"line", "stations:\na,1,1\nb,2,1\nc,3,1\nconnections:\na-b\nb-c\n", "c", "a", true},
+		// b and c are both two tracks from a; the first name wins.
+		{"tie", "stations:\na,1,1\nx,2,1\nb,3,1\nc,3,2\nconnections:\na-x\nx-b\nx-c\n", "b", "a", true},
+		// a has no tracks, so the search starts from b instead.
+		{"isolated station", "stations:\na,1,1\nb,2,1\nc,3,1\nconnections:\nb-c\n", "c", "b", true},
+		{"no tracks", "stations:\na,1,1\nb,2,1\nconnections:\n", "", "", false},
+	} {
+		data := parseMap(strings.NewReader(c.text), ParseConfig{})
+		start, end, ok := farthestPair(data.Stations, data.Connections)
+		if start != c.start || end != c.end || ok != c.ok {
+			t.Errorf("%s: got %s, %s, %v, want %s, %s, %v", c.name, start, end, ok, c.start, c.end, c.ok)
+		}
+	}
+}
+
+func TestCompareMapsSkipsMapsWithErrors(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "london.txt"), watchMap)
+	writeFile(t, filepath.Join(dir, "broken.txt"), "stations:\na,1,1\nconnections:\na-nowhere\n")
+	writeFile(t, filepath.Join(dir, "lonely.txt"), "stations:\na,1,1\nb,2,2\nconnections:\n")
+	var out strings.Builder
+	if err := compareMaps(&out, dir, 4, 0, ParseConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != 2+len(planners)+1 {
+		t.Fatalf("expected a header, a row for broken.txt, one for every planner and one for lonely.txt, got\n%s", out.String())
+	}
+	if got := strings.Join(strings.Fields(lines[1]), " "); got != "broken.txt skipped, the map has errors" {
+		t.Errorf("broken.txt row %q", lines[1])
+	}
+	if got := strings.Join(strings.Fields(lines[len(lines)-1]), " "); got != "lonely.txt skipped, no two stations are connected" {
+		t.Errorf("lonely.txt row %q", lines[len(lines)-1])
+	}
+	for i, p := range planners {
+		fields := strings.Fields(lines[2+i])
+		if i == 0 {
+			fields = fields[4:] // london.txt victoria -> euston
+		}
+		if len(fields) < 3 || fields[0] != p.Name() || fields[1] != "3" || fields[2] != "2" {
+			t.Errorf("row for %s: %q, want 3 turns on 2 routes", p.Name(), lines[2+i])
+		}
+	}
+	if err := compareMaps(&out, t.TempDir(), 4, 0, ParseConfig{}); err == nil || !strings.Contains(err.Error(), "no map files") {
+		t.Errorf("an empty directory gave %v", err)
+	}
+}
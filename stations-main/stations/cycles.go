@@ -0,0 +1,23 @@
+package main
+
+// roundTrips turns routes from start to end into routes that run there and
+// back cycles times. Every way out is paired with every way back, so the
+// planner can send trains home on a different route than they came. Routes
+// are in travelling order.
+func roundTrips(routes [][]string, cycles int) [][]string {
+	var trips [][]string
+	for _, out := range routes {
+		for _, back := range routes {
+			cycle := append([]string(nil), out...)
+			for i := len(back) - 2; i >= 0; i-- {
+				cycle = append(cycle, back[i])
+			}
+			trip := []string{cycle[0]}
+			for c := 0; c < cycles; c++ {
+				trip = append(trip, cycle[1:]...)
+			}
+			trips = append(trips, trip)
+		}
+	}
+	return trips
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestRoundTripsPairEveryWayOutWithEveryWayBack(t *testing.T) {
+	routes := [][]string{{"a", "b", "c"}, {"a", "d", "c"}}
+	want := [][]string{
+		{"a", "b", "c", "b", "a", "b", "c", "b", "a"},
+		{"a", "b", "c", "d", "a", "b", "c", "d", "a"},
+		{"a", "d", "c", "b", "a", "d", "c", "b", "a"},
+		{"a", "d", "c", "d", "a", "d", "c", "d", "a"},
+	}
+	if got := roundTrips(routes, 2); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+// roundTripRun plans london from waterloo to st_pancras and runs the trains
+// there and back as main does for --cycles.
+func roundTripRun(t *testing.T, traincount, cycles int) ([]*Train, [][]Move) {
+	t.Helper()
+	data, err := loadMap([]string{"maps/london.txt"}, ParseConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths, _, err := planRoutes(context.Background(), data.Stations, data.Connections, "waterloo", "st_pancras", traincount, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := ScheduleConfig{Terminals: []string{"st_pancras"}}
+	trains := startTrains(traincount, "waterloo")
+	order, _, ok := assignRoutes(trains, roundTrips(forwardAll(paths), cycles), cfg)
+	if !ok {
+		t.Fatal("no route for some trains")
+	}
+	turns, err := runSchedule(order, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return trains, turns
+}
+
+func TestRoundTripsAlternateBetweenTheEnds(t *testing.T) {
+	_, turns := roundTripRun(t, 1, 2)
+	var ends []string
+	for n, turn := range turns {
+		if len(turn) != 1 {
+			t.Fatalf("turn %d: %v", n+1, turn)
+		}
+		if at := turn[0].Station; at == "waterloo" || at == "st_pancras" {
+			ends = append(ends, at)
+		}
+	}
+	want := []string{"st_pancras", "waterloo", "st_pancras", "waterloo"}
+	if len(turns) != 8 || !reflect.DeepEqual(ends, want) {
+		t.Fatalf("got %d turns reaching %v, want 8 reaching %v", len(turns), ends, want)
+	}
+}
+
+// With more trains the ones coming back share stations with those still
+// heading out, and the start and end hold any number of them.
+func TestRoundTripsShareTheNetwork(t *testing.T) {
+	trains, turns := roundTripRun(t, 3, 2)
+	location := make(map[string]string)
+	visits := make(map[string]int)
+	for n, turn := range turns {
+		taken := make(map[string]string)
+		for _, m := range turn {
+			location[m.Train] = m.Station
+			if m.Station == "st_pancras" {
+				visits[m.Train]++
+			}
+		}
+		for train, at := range location {
+			if other, ok := taken[at]; ok && at != "waterloo" && at != "st_pancras" {
+				t.Fatalf("turn %d: %s and %s both at %s", n+1, train, other, at)
+			}
+			taken[at] = train
+		}
+	}
+	for _, train := range trains {
+		if location[train.Name] != "waterloo" || visits[train.Name] != 2 || train.Arrived == 0 {
+			t.Fatalf("%s ended at %s after %d visit(s) to st_pancras", train.Name, location[train.Name], visits[train.Name])
+		}
+	}
+	if len(turns) != 9 {
+		t.Fatalf("%d turns, want 9", len(turns))
+	}
+}
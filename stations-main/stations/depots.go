@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Depot is a station where some of the trains are parked at the start.
+type Depot struct {
+	Station string
+	Trains  int
+}
+
+func parseDepots(values []string) ([]Depot, error) {
+	var depots []Depot
+	seen := make(map[string]bool)
+	for _, value := range values {
+		station, count, ok := strings.Cut(value, ":")
+		if !ok || station == "" {
+			return nil, fmt.Errorf("depot (%s) should be given as station:trains", value)
+		}
+		n, err := strconv.Atoi(count)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("unable to convert train numbers(%s) of depot %s to a positive integer", count, station)
+		}
+		if seen[station] {
+			return nil, fmt.Errorf("depot %s given more than once", station)
+		}
+		seen[station] = true
+		depots = append(depots, Depot{Station: station, Trains: n})
+	}
+	return depots, nil
+}
+
+// planDepots chooses routes from every depot to end and assigns the trains to
+// them. Routes share no stations, so trains from different depots never meet
+// before the end. Each extra route is the shortest one that still fits, and
+// the route set finishing in the fewest turns wins. When ctx ends before every
+// route set has been tried, the best run found so far is returned and complete
+// is false; the search always goes on until it has found one run.
+func planDepots(ctx context.Context, stations map[string]Station, connections map[string][]string, depots []Depot, end string, windows map[string]trainWindow, cfg ScheduleConfig, trace *Explainer) (run *depotRun, complete bool, err error) {
+	origins := make(map[string]int)
+	total := 0
+	for _, d := range depots {
+		if d.Station == end {
+			return nil, false, fmt.Errorf("depot %s is the end station", d.Station)
+		}
+		origins[d.Station] = d.Trains
+		total += d.Trains
+	}
+	f := newRouteFlow(stations, connections, origins, end)
+	var best *depotRun
+	bestTurns := int(^uint(0) >> 1)
+	complete = true
+	for k := 1; k <= total; k++ {
+		if best != nil && ctx.Err() != nil {
+			complete = false
+			break
+		}
+		if !f.augment() {
+			break
+		}
+		routes := f.routes()
+		run, turns := assignDepotTrains(routes, depots, windows, cfg)
+		trace.note("depot-routes", map[string]interface{}{"routes": routes, "turns": turns},
+			"%d route(s) would need %s", len(routes), turnsText(turns))
+		if run != nil && turns < bestTurns {
+			best, bestTurns = run, turns
+		}
+	}
+	if best == nil {
+		for _, d := range depots {
+			if !reachable(stations, connections, d.Station, end) {
+				return nil, false, fmt.Errorf("no valid path between %s and %s", d.Station, end)
+			}
+		}
+		return nil, false, fmt.Errorf("depot routes to %s cannot avoid passing through another depot", end)
+	}
+	return best, complete, nil
+}
+
+func turnsText(turns int) string {
+	if turns == int(^uint(0)>>1) {
+		return "no route for some depot"
+	}
+	return strconv.Itoa(turns) + " turns"
+}
+
+// depotRun is the trains of a depot run, named in depot order, and the order
+// they leave in.
+type depotRun struct {
+	trains, order []*Train
+}
+
+// assignDepotTrains parks the trains at their depots, naming them in depot
+// order, and spreads them over the routes. It returns nil if a depot has no
+// route at all.
+func assignDepotTrains(routes [][]string, depots []Depot, windows map[string]trainWindow, cfg ScheduleConfig) (*depotRun, int) {
+	var trains []*Train
+	for _, d := range depots {
+		for n := 0; n < d.Trains; n++ {
+			trains = append(trains, &Train{Name: "T" + strconv.Itoa(len(trains)+1), Route: []string{d.Station}})
+		}
+	}
+	if applyWindows(trains, windows) != nil {
+		return nil, int(^uint(0) >> 1)
+	}
+	order, turns, ok := assignRoutes(trains, routes, cfg)
+	if !ok {
+		return nil, int(^uint(0) >> 1)
+	}
+	return &depotRun{trains: trains, order: order}, turns
+}
+
+func depotMain(args []string, opts *options, trace *Explainer) {
+	depots, err := parseDepots(opts.depots)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		return
+	}
+	end := args[2]
+	stations, connections, lines, valid, ok := readNetwork(append([]string{args[1]}, opts.maps...), depots[0].Station, end, opts.parse)
+	if !ok {
+		return
+	}
+	for _, d := range depots[1:] {
+		if !hasStation(stations, d.Station) {
+			fmt.Fprintf(os.Stderr, "Error: Depot station (%s) was not found within the train map\n", d.Station)
+			return
+		}
+	}
+	if !valid {
+		fmt.Println(Red, "Please fix listed errors", Reset)
+		return
+	}
+	total := 0
+	for _, d := range depots {
+		total += d.Trains
+	}
+	windows, err := loadWindows(opts)
+	if err == nil {
+		err = applyWindows(startTrains(total, ""), windows)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		return
+	}
+	ctx := context.Background()
+	if opts.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.timeout)
+		defer cancel()
+	}
+	run, complete, err := planDepots(ctx, stations, connections, depots, end, windows, opts.schedule, trace)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		return
+	}
+	if !complete {
+		fmt.Fprintf(os.Stderr, "Note: the route search ran out of time after %s, the schedule may not be optimal\n", opts.timeout)
+	}
+	trains := run.trains
+	turns, err := runSchedule(run.order, opts.schedule)
+	printTurns(turns, newTurnPainter(lines, trainOrigins(trains), nil))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+	}
+	reportDeadlines(os.Stdout, trains)
+	reportPriorities(os.Stdout, trains)
+	writeStats(opts, stations, connections, trainOrigins(trains), turns)
+}
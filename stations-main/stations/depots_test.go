@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestDepotsOnLondon(t *testing.T) {
+	data, err := loadMap([]string{"maps/london.txt"}, ParseConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	depots := []Depot{{"waterloo", 3}, {"euston", 2}}
+	for _, cfg := range []ScheduleConfig{{}, {Block: true}} {
+		run, _, err := planDepots(context.Background(), data.Stations, data.Connections, depots, "st_pancras", nil, cfg, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, train := range run.trains {
+			names = append(names, train.Name+"@"+train.Route[0])
+		}
+		if got := strings.Join(names, " "); got != "T1@waterloo T2@waterloo T3@waterloo T4@euston T5@euston" {
+			t.Fatalf("trains parked as %s", got)
+		}
+		turns, err := runSchedule(run.order, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := checkSchedule(data.Connections, trainOrigins(run.trains), "st_pancras", turns); err != nil {
+			t.Fatalf("%+v: %s", cfg, err)
+		}
+		if len(turns) != 4 {
+			t.Fatalf("%+v: %d turns, want 4: %v", cfg, len(turns), turns)
+		}
+	}
+}
+
+// Once the time is up the search stops, but only after it has found a run.
+func TestDepotsStopWhenTimeRunsOut(t *testing.T) {
+	data, err := loadMap([]string{"maps/london.txt"}, ParseConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	depots := []Depot{{"waterloo", 3}, {"euston", 2}}
+	run, complete, err := planDepots(ctx, data.Stations, data.Connections, depots, "st_pancras", nil, ScheduleConfig{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if complete || len(run.trains) != 5 {
+		t.Fatalf("complete %v with %d trains, want an incomplete run of 5", complete, len(run.trains))
+	}
+}
+
+func TestDepotErrors(t *testing.T) {
+	line := parseMap(strings.NewReader("stations:\na,0,0\nb,1,0\nc,2,0\nd,5,5\nconnections:\na-b\nb-c\n"), ParseConfig{})
+	for _, c := range []struct {
+		depots []Depot
+		end    string
+		want   string
+	}{
+		{[]Depot{{"a", 1}, {"c", 1}}, "c", "depot c is the end station"},
+		{[]Depot{{"d", 1}}, "c", "no valid path between d and c"},
+		{[]Depot{{"a", 1}, {"b", 1}}, "c", "cannot avoid passing through another depot"},
+	} {
+		_, _, err := planDepots(context.Background(), line.Stations, line.Connections, c.depots, c.end, nil, ScheduleConfig{}, nil)
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("depots %v to %s: got %v, want %q", c.depots, c.end, err, c.want)
+		}
+	}
+}
+
+// assignRoutes places trains by priority, but the caller's slice has to stay
+// in train order for the reports.
+func TestAssignRoutesLeavesTrainsInOrder(t *testing.T) {
+	trains := startTrains(3, "a")
+	trains[2].Priority = 2
+	order, _, ok := assignRoutes(trains, [][]string{{"a", "b", "c"}}, ScheduleConfig{})
+	if !ok {
+		t.Fatal("no route for some trains")
+	}
+	for i, train := range trains {
+		if want := "T" + string(rune('1'+i)); train.Name != want {
+			t.Fatalf("trains reordered, %s at %d", train.Name, i)
+		}
+	}
+	if order[0].Name != "T3" || order[0].Depart != 1 {
+		t.Fatalf("T3 has the highest priority and should leave first, got %s in turn %d", order[0].Name, order[0].Depart)
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// pairEffect describes how one network serves an origin/destination pair.
+type pairEffect struct {
+	err      error
+	shortest []string
+	disjoint int
+	turns    int
+}
+
+func diffMain(args []string, cfg ParseConfig) {
+	if len(args) != 2 && len(args) != 4 && len(args) != 5 {
+		fmt.Fprintf(os.Stderr, "Error: incorrect number of arguments for diff\n")
+		fmt.Println(Green, " To compare two maps:")
+		fmt.Println("  go run . diff <old map> <new map> [<start station> <end station> [<numeric amount of trains>]]", Reset)
+		os.Exit(0)
+	}
+	oldNet, err := loadNetwork(args[0], cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Println(Red, "Please fix listed errors in", args[0], Reset)
+		os.Exit(0)
+	}
+	newNet, err := loadNetwork(args[1], cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Println(Red, "Please fix listed errors in", args[1], Reset)
+		os.Exit(0)
+	}
+	traincount := 0
+	if len(args) == 5 {
+		traincount, err = strconv.Atoi(args[4])
+		if err != nil || traincount < 1 {
+			fmt.Fprintf(os.Stderr, "Error: unable to convert train numbers(%s) to a positive integer\n", args[4])
+			os.Exit(0)
+		}
+	}
+	diffNetworks(os.Stdout, oldNet, newNet)
+	if len(args) >= 4 {
+		diffPair(os.Stdout, oldNet, newNet, args[2], args[3], traincount)
+	}
+}
+
+// diffNetworks prints the stations and connections that differ between two
+// versions of a map.
+func diffNetworks(w io.Writer, oldNet, newNet *Network) {
+	var added, removed, moved []string
+	for _, name := range newNet.StationNames() {
+		st := newNet.Stations[name]
+		old, exists := oldNet.Stations[name]
+		if !exists {
+			added = append(added, fmt.Sprintf("%s (%s)", name, st.Coordinates()))
+		} else if !samePlace(old, st) {
+			moved = append(moved, fmt.Sprintf("%s (%s) -> (%s)", name, old.Coordinates(), st.Coordinates()))
+		}
+	}
+	for _, name := range oldNet.StationNames() {
+		if _, exists := newNet.Stations[name]; !exists {
+			st := oldNet.Stations[name]
+			removed = append(removed, fmt.Sprintf("%s (%s)", name, st.Coordinates()))
+		}
+	}
+
+	oldTracks := make(map[string]bool)
+	for _, track := range oldNet.Tracks() {
+		oldTracks[track] = true
+	}
+	newTracks := make(map[string]bool)
+	var addedTracks, removedTracks []string
+	for _, track := range newNet.Tracks() {
+		newTracks[track] = true
+		if !oldTracks[track] {
+			addedTracks = append(addedTracks, track)
+		}
+	}
+	for _, track := range oldNet.Tracks() {
+		if !newTracks[track] {
+			removedTracks = append(removedTracks, track)
+		}
+	}
+
+	if len(added)+len(removed)+len(moved)+len(addedTracks)+len(removedTracks) == 0 {
+		fmt.Fprintln(w, "No changes to stations or connections")
+		return
+	}
+	printSection := func(title, sign string, items []string) {
+		if len(items) == 0 {
+			return
+		}
+		fmt.Fprintf(w, "%s:\n", title)
+		for _, item := range items {
+			fmt.Fprintf(w, "  %s %s\n", sign, item)
+		}
+	}
+	printSection("Stations added", "+", added)
+	printSection("Stations removed", "-", removed)
+	printSection("Stations moved", "~", moved)
+	printSection("Connections added", "+", addedTracks)
+	printSection("Connections removed", "-", removedTracks)
+}
+
+// hasPath reports whether every track of path exists in the network.
+func hasPath(network *Network, path []string) bool {
+	for i := 1; i < len(path); i++ {
+		if !contains(network.Connections[path[i-1]], path[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func evaluatePair(network *Network, start, end string, traincount int) pairEffect {
+	var effect pairEffect
+	effect.shortest, effect.err = shortestPath(network, start, end)
+	if effect.err != nil {
+		return effect
+	}
+	effect.disjoint = len(disjointRoutes(network.Stations, network.Connections, start, end))
+	if traincount > 0 {
+		paths, err := networkRoutes(network, start, end, traincount)
+		if err != nil {
+			effect.err = err
+			return effect
+		}
+		effect.turns = len(simulate(Trainnames(traincount, start), paths, network.Stations, start, nil))
+	}
+	return effect
+}
+
+// diffPair prints how the change affects trains between start and end.
+func diffPair(w io.Writer, oldNet, newNet *Network, start, end string, traincount int) {
+	before := evaluatePair(oldNet, start, end, traincount)
+	after := evaluatePair(newNet, start, end, traincount)
+	if before.err == nil && after.err == nil && len(before.shortest) == len(after.shortest) && hasPath(newNet, before.shortest) {
+		// Dijkstra picks any of several equally short paths, so only report a
+		// change when the old path is gone or got longer.
+		after.shortest = before.shortest
+	}
+	fmt.Fprintf(w, "Between %s and %s:\n", start, end)
+
+	describe := func(e pairEffect) string {
+		if e.err != nil {
+			return e.err.Error()
+		}
+		return fmt.Sprintf("%s (%d stops)", strings.Join(e.shortest, " -> "), len(e.shortest)-1)
+	}
+	compare := func(label, old, new string) {
+		if old == new {
+			fmt.Fprintf(w, "  %s: %s (unchanged)\n", label, old)
+		} else {
+			fmt.Fprintf(w, "  %s: %s -> %s\n", label, old, new)
+		}
+	}
+	compare("Shortest path", describe(before), describe(after))
+	if before.err != nil || after.err != nil {
+		return
+	}
+	compare("Disjoint routes", strconv.Itoa(before.disjoint), strconv.Itoa(after.disjoint))
+	if traincount > 0 {
+		compare(fmt.Sprintf("Turns for %d trains", traincount), strconv.Itoa(before.turns), strconv.Itoa(after.turns))
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func diffNetwork(t *testing.T, text string) *Network {
+	t.Helper()
+	data := parseMap(strings.NewReader(text), ParseConfig{})
+	if len(data.Errors) > 0 {
+		t.Fatal(data.Errors)
+	}
+	return newNetwork(data.Stations, data.Connections)
+}
+
+func TestDiffNetworks(t *testing.T) {
+	for _, c := range []struct {
+		name, newMap, want string
+	}{
+		{"unchanged", watchMap, "No changes to stations or connections\n"},
+		{"stations and tracks", `stations:
+waterloo,3,1
+victoria,6,8
+st_pancras,5,15
+bank,8,8
+connections:
+waterloo-victoria
+victoria-st_pancras
+waterloo-st_pancras
+bank-victoria
+`, `Stations added:
+  + bank (8,8)
+Stations removed:
+  - euston (11,23)
+Stations moved:
+  ~ victoria (6,7) -> (6,8)
+Connections added:
+  + bank-victoria
+  + st_pancras-waterloo
+Connections removed:
+  - euston-st_pancras
+  - euston-waterloo
+`},
+		{"tracks only", watchMap + "waterloo-st_pancras\n", `Connections added:
+  + st_pancras-waterloo
+`},
+	} {
+		var out strings.Builder
+		diffNetworks(&out, diffNetwork(t, watchMap), diffNetwork(t, c.newMap))
+		if out.String() != c.want {
+			t.Errorf("%s: got\n%swant\n%s", c.name, out.String(), c.want)
+		}
+	}
+}
+
+func TestDiffPair(t *testing.T) {
+	for _, c := range []struct {
+		name, newMap string
+		trains       int
+		want         string
+	}{
+		{"unchanged", watchMap, 4, `Between waterloo and st_pancras:
+  Shortest path: waterloo -> euston -> st_pancras (2 stops) (unchanged)
+  Disjoint routes: 2 (unchanged)
+  Turns for 4 trains: 3 (unchanged)
+`},
+		// A third route takes a turn off moving four trains.
+		{"shortcut", watchMap + "waterloo-st_pancras\n", 4, `Between waterloo and st_pancras:
+  Shortest path: waterloo -> euston -> st_pancras (2 stops) -> waterloo -> st_pancras (1 stops)
+  Disjoint routes: 2 -> 3
+  Turns for 4 trains: 3 -> 2
+`},
+		{"without trains", watchMap + "waterloo-st_pancras\n", 0, `Between waterloo and st_pancras:
+  Shortest path: waterloo -> euston -> st_pancras (2 stops) -> waterloo -> st_pancras (1 stops)
+  Disjoint routes: 2 -> 3
+`},
+		{"cut off", strings.Replace(strings.Replace(watchMap, "st_pancras-euston\n", "", 1), "victoria-st_pancras\n", "", 1), 4, `Between waterloo and st_pancras:
+  Shortest path: waterloo -> euston -> st_pancras (2 stops) -> no valid path between waterloo and st_pancras
+`},
+	} {
+		var out strings.Builder
+		diffPair(&out, diffNetwork(t, watchMap), diffNetwork(t, c.newMap), "waterloo", "st_pancras", c.trains)
+		if out.String() != c.want {
+			t.Errorf("%s: got\n%swant\n%s", c.name, out.String(), c.want)
+		}
+	}
+}
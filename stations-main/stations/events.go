@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bufio"
+	"container/heap"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Timings are the lengths of time --continuous runs with, in the units of a
+// turn: a track takes one unit unless the scenario says otherwise.
+type Timings struct {
+	Tracks  map[string]float64 // time to run a track, keyed by trackKey
+	Dwell   map[string]float64 // time a train stops at a station on its way
+	Headway float64            // least time between two trains entering a track
+	Stop    float64            // dwell at stations not listed in Dwell
+}
+
+func (tm Timings) track(a, b string) float64 {
+	if d, ok := tm.Tracks[trackKey(a, b)]; ok {
+		return d
+	}
+	return 1
+}
+
+func (tm Timings) dwell(station string) float64 {
+	if d, ok := tm.Dwell[station]; ok {
+		return d
+	}
+	return tm.Stop
+}
+
+// readTimings parses the timing sections of a scenario file:
+//
+//	tracks:
+//	waterloo-victoria,2.5   # the track takes 2.5 turns to run
+//	dwell:
+//	victoria,0.5            # trains stop half a turn at victoria
+//
+// Other sections are left to readScenario.
+func readTimings(r io.Reader, tm *Timings) error {
+	scanner := bufio.NewScanner(r)
+	section := ""
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.ReplaceAll(scanner.Text(), " ", "")
+		line, _, _ = strings.Cut(line, "#")
+		if strings.HasSuffix(line, ":") {
+			section = strings.TrimSuffix(line, ":")
+			continue
+		}
+		if line == "" || section != "tracks" && section != "dwell" {
+			continue
+		}
+		name, value, ok := strings.Cut(line, ",")
+		if !ok {
+			if section == "tracks" {
+				return fmt.Errorf("line %d: track should be given as station-station,time", lineNo)
+			}
+			return fmt.Errorf("line %d: dwell should be given as station,time", lineNo)
+		}
+		d, err := parseTime(value)
+		if err != nil {
+			return fmt.Errorf("line %d: %s", lineNo, err)
+		}
+		if section == "dwell" {
+			tm.Dwell[name] = d
+			continue
+		}
+		a, b, ok := strings.Cut(name, "-")
+		if !ok || a == "" || b == "" {
+			return fmt.Errorf("line %d: track should be given as station-station,time", lineNo)
+		}
+		if d == 0 {
+			return fmt.Errorf("line %d: track %s should take some time", lineNo, name)
+		}
+		tm.Tracks[trackKey(a, b)] = d
+	}
+	return scanner.Err()
+}
+
+func parseTime(value string) (float64, error) {
+	d, err := strconv.ParseFloat(value, 64)
+	if err != nil || d < 0 || math.IsInf(d, 0) || math.IsNaN(d) {
+		return 0, fmt.Errorf("unable to convert time (%s) to a non-negative number", value)
+	}
+	return d, nil
+}
+
+// loadTimings reads the timings of --scenario and checks that its tracks and
+// stations are on the map.
+func loadTimings(opts *options, stations map[string]Station, connections map[string][]string) (Timings, error) {
+	tm := Timings{Tracks: make(map[string]float64), Dwell: make(map[string]float64), Headway: opts.headway, Stop: opts.dwell}
+	if opts.scenario == "" {
+		return tm, nil
+	}
+	file, err := os.Open(opts.scenario)
+	if err != nil {
+		return tm, err
+	}
+	err = readTimings(file, &tm)
+	file.Close()
+	if err != nil {
+		return tm, fmt.Errorf("%s: %s", opts.scenario, err)
+	}
+	for key := range tm.Tracks {
+		if a, b, ok := cutTrack(key, stations); !ok || !contains(connections[a], b) {
+			return tm, fmt.Errorf("%s: track %s is not on the map", opts.scenario, key)
+		}
+	}
+	for station := range tm.Dwell {
+		if !hasStation(stations, station) {
+			return tm, fmt.Errorf("%s: station %s is not on the map", opts.scenario, station)
+		}
+	}
+	return tm, nil
+}
+
+// TimedMove is a train running one track in continuous time.
+type TimedMove struct {
+	Train, From, Station string
+	Depart, Arrive       float64
+}
+
+// trainEvent wakes the simulation at a time: a train arrives, finishes its
+// dwell or may leave, or a track comes free.
+type trainEvent struct {
+	time  float64
+	seq   int
+	train *Train // the arriving train, nil for a wake-up
+}
+
+type eventQueue []trainEvent
+
+func (q eventQueue) Len() int { return len(q) }
+
+func (q eventQueue) Less(a, b int) bool {
+	if q[a].time != q[b].time {
+		return q[a].time < q[b].time
+	}
+	return q[a].seq < q[b].seq
+}
+
+func (q eventQueue) Swap(a, b int) { q[a], q[b] = q[b], q[a] }
+
+func (q *eventQueue) Push(x interface{}) { *q = append(*q, x.(trainEvent)) }
+
+func (q *eventQueue) Pop() interface{} {
+	old := *q
+	e := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return e
+}
+
+// runEvents moves trains along their routes in continuous time, going from
+// one event to the next instead of turn by turn. The rules are those of
+// runSchedule: a station other than a route end holds one train, which takes
+// it from the moment it leaves for it until it leaves again, a long train
+// takes the blocks behind it as it leaves for a station, and a track
+// (one direction of it unless cfg.Block) takes a train at a time, for as long
+// as it is on the track and at least the headway after it entered. A train
+// stops for the dwell time at every station on its way and leaves no earlier
+// than the start of its release or planned departure turn. Whenever trains
+// may move, those further along their route go first, as in runSchedule, so
+// with the default timings both give the same moves.
+func runEvents(trains []*Train, cfg ScheduleConfig, tm Timings) ([]TimedMove, error) {
+	occupied := make(blockMap)
+	terminal := cfg.terminals(trains)
+	for _, t := range trains {
+		occupied.move(t, t.Pos, t.Pos, terminal)
+	}
+	queue := &eventQueue{}
+	seq := 0
+	push := func(e trainEvent) {
+		seq++
+		e.seq = seq
+		heap.Push(queue, e)
+	}
+	ready := make(map[string]float64) // time each train may leave its station
+	moving := make(map[string]bool)   // trains out on a track
+	busy := make(map[string]float64)  // time each track comes free
+	for _, t := range trains {
+		ready[t.Name] = float64(max(t.Release, t.Depart, 1) - 1)
+		push(trainEvent{time: ready[t.Name]})
+	}
+
+	var moves []TimedMove
+	for queue.Len() > 0 {
+		now := (*queue)[0].time
+		for queue.Len() > 0 && (*queue)[0].time == now {
+			e := heap.Pop(queue).(trainEvent)
+			if e.train == nil {
+				continue
+			}
+			t := e.train
+			t.Pos++
+			delete(moving, t.Name)
+			if t.Done() {
+				t.Arrived = int(math.Ceil(now))
+				continue
+			}
+			ready[t.Name] = now + tm.dwell(t.Location())
+			if ready[t.Name] > now {
+				push(trainEvent{time: ready[t.Name]})
+			}
+		}
+
+		var waiting []*Train
+		for _, t := range trains {
+			if !t.Done() && !moving[t.Name] && ready[t.Name] <= now {
+				waiting = append(waiting, t)
+			}
+		}
+		sort.SliceStable(waiting, func(a, b int) bool { return waiting[a].Pos > waiting[b].Pos })
+		for progress := true; progress; {
+			progress = false
+			for _, t := range waiting {
+				if moving[t.Name] {
+					continue
+				}
+				next := t.Route[t.Pos+1]
+				use := cfg.trackUse(t.Location(), next)
+				if !occupied.free(t, t.Pos, t.Pos+1, terminal) || busy[use] > now {
+					continue
+				}
+				occupied.move(t, t.Pos, t.Pos+1, terminal)
+				d := tm.track(t.Location(), next)
+				busy[use] = now + math.Max(d, tm.Headway)
+				if tm.Headway > d {
+					push(trainEvent{time: busy[use]})
+				}
+				moving[t.Name] = true
+				moves = append(moves, TimedMove{Train: t.Name, From: t.Location(), Station: next, Depart: now, Arrive: now + d})
+				push(trainEvent{time: now + d, train: t})
+				progress = true
+			}
+		}
+	}
+	for _, t := range trains {
+		if !t.Done() {
+			return moves, fmt.Errorf("trains are stuck at %s", t.Location())
+		}
+	}
+	sort.SliceStable(moves, func(a, b int) bool { return moves[a].Arrive < moves[b].Arrive })
+	return moves, nil
+}
+
+// timedTurns groups the moves by the turn they end in, a move arriving at time
+// a counting in turn ⌈a⌉, so the run can be printed and measured like one of
+// runSchedule. With the default timings the turns are exactly those of
+// runSchedule.
+func timedTurns(moves []TimedMove) [][]Move {
+	var turns [][]Move
+	for _, m := range moves {
+		turn := max(int(math.Ceil(m.Arrive)), 1)
+		for len(turns) < turn {
+			turns = append(turns, nil)
+		}
+		turns[turn-1] = append(turns[turn-1], Move{Train: m.Train, Station: m.Station})
+	}
+	return turns
+}
+
+func formatTime(t float64) string {
+	return strconv.FormatFloat(t, 'f', -1, 64)
+}
+
+// printTimeline prints the moves arriving at the same time on one line, after
+// the time, in the colours of the lines when there is a painter.
+func printTimeline(moves []TimedMove, paint *turnPainter) {
+	for i := 0; i < len(moves); {
+		j := i
+		var group []Move
+		for ; j < len(moves) && moves[j].Arrive == moves[i].Arrive; j++ {
+			group = append(group, Move{Train: moves[j].Train, Station: moves[j].Station})
+		}
+		text := formatTurn(group)
+		if paint != nil {
+			text = paint.format(group)
+		}
+		fmt.Println(Blue, formatTime(moves[i].Arrive)+": "+text, Reset)
+		i = j
+	}
+}
+
+// runContinuous is runTrains for --continuous: it schedules the trains as
+// runTrains does, runs them with runEvents and prints the moves and the
+// deadline report. The moves are printed by turn as a normal run prints them,
+// or with timeline by the time they arrive. It returns the moves grouped
+// into turns for the stats.
+func runContinuous(trains []*Train, routes [][]string, cfg ScheduleConfig, tm Timings, lines []MapLine, timeline bool) [][]Move {
+	order, _, ok := assignRoutes(trains, routes, cfg)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Error: no route for some trains\n")
+		return nil
+	}
+	moves, err := runEvents(order, cfg, tm)
+	turns := timedTurns(moves)
+	paint := newTurnPainter(lines, trainOrigins(trains), trainLines(trains))
+	if timeline {
+		printTimeline(moves, paint)
+	} else {
+		printTurns(turns, paint)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+	} else if timeline && len(moves) > 0 {
+		fmt.Printf("All trains arrived at %s\n", formatTime(moves[len(moves)-1].Arrive))
+	}
+	reportDeadlines(os.Stdout, trains)
+	reportPriorities(os.Stdout, trains)
+	return turns
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// maxExactStations is the largest map the exact solver takes on. Its network
+// grows with stations times turns, which is fine for a few dozen stations.
+const maxExactStations = 50
+
+// timeFlow is a max-flow network over the stations copied once per turn. A
+// train at station v at the end of turn t is flow through node (v, t); it
+// either waits and goes on to (v, t+1) or takes a track to (w, t+1). Stations
+// other than start and end are split into an entering and a leaving node with
+// capacity 1 between them, so they hold one train at the end of a turn, and
+// every track carries one train per turn in each direction.
+type timeFlow struct {
+	to, capacity []int
+	adj          [][]int // edge indices leaving each node
+}
+
+func (f *timeFlow) addEdge(u, v, capacity int) int {
+	f.to = append(f.to, v, u)
+	f.capacity = append(f.capacity, capacity, 0)
+	f.adj[u] = append(f.adj[u], len(f.to)-2)
+	f.adj[v] = append(f.adj[v], len(f.to)-1)
+	return len(f.to) - 2
+}
+
+// augment sends one unit along a shortest augmenting path, returning false
+// when there is none.
+func (f *timeFlow) augment(source, sink int) bool {
+	via := make([]int, len(f.adj))
+	for i := range via {
+		via[i] = -1
+	}
+	via[source] = -2
+	queue := []int{source}
+	for len(queue) > 0 && via[sink] == -1 {
+		u := queue[0]
+		queue = queue[1:]
+		for _, e := range f.adj[u] {
+			if v := f.to[e]; f.capacity[e] > 0 && via[v] == -1 {
+				via[v] = e
+				queue = append(queue, v)
+			}
+		}
+	}
+	if via[sink] == -1 {
+		return false
+	}
+	for v := sink; v != source; v = f.to[via[v]^1] {
+		f.capacity[via[v]]--
+		f.capacity[via[v]^1]++
+	}
+	return true
+}
+
+// exactMove is a track arc of the time-expanded network: a train going from
+// one station to another in a turn.
+type exactMove struct {
+	edge     int
+	turn     int
+	from, to string
+}
+
+// exactSchedule finds a schedule in the fewest turns there can be for
+// traincount trains from start to end, by trying one turn more at a time
+// until a max flow over the time-expanded network carries every train. It is
+// the yardstick for the planner in the tests and only takes small maps.
+func exactSchedule(stations map[string]Station, connections map[string][]string, start, end string, traincount int) ([][]Move, error) {
+	if len(stations) > maxExactStations {
+		return nil, fmt.Errorf("the exact solver takes up to %d stations, the map has %d", maxExactStations, len(stations))
+	}
+	if start == end || !reachable(stations, connections, start, end) {
+		return nil, fmt.Errorf("no valid path between %s and %s", start, end)
+	}
+	if traincount < 1 {
+		return nil, nil
+	}
+	names := make([]string, 0, len(stations))
+	for name := range stations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	// Trains sent down one shortest route a turn apart always fit, so the
+	// search ends by the length of that route plus a turn per extra train.
+	for turns := len(shortestHops(connections, start, end, nil)) - 1; ; turns++ {
+		if moves, ok := timeExpandedFlow(names, connections, start, end, traincount, turns); ok {
+			return exactMoves(moves, start, traincount, turns), nil
+		}
+	}
+}
+
+// timeExpandedFlow tries to fit traincount trains into the given number of
+// turns. It returns the track arcs the flow uses when they all fit.
+func timeExpandedFlow(names []string, connections map[string][]string, start, end string, traincount, turns int) ([]exactMove, bool) {
+	node := func(station, turn, side int) int { return (station*(turns+1)+turn)*2 + side }
+	source := 2 * len(names) * (turns + 1)
+	sink := source + 1
+	f := &timeFlow{adj: make([][]int, sink+1)}
+	index := make(map[string]int, len(names))
+	for i, name := range names {
+		index[name] = i
+	}
+	var moves []exactMove
+	for i, name := range names {
+		hold := 1
+		if name == start || name == end {
+			hold = traincount
+		}
+		for t := 0; t <= turns; t++ {
+			f.addEdge(node(i, t, 0), node(i, t, 1), hold)
+			if name == end {
+				f.addEdge(node(i, t, 1), sink, traincount)
+				continue // trains stop at the end
+			}
+			if t == turns {
+				continue
+			}
+			f.addEdge(node(i, t, 1), node(i, t+1, 0), traincount)
+			neighbours := append([]string(nil), connections[name]...)
+			sort.Strings(neighbours)
+			for _, next := range neighbours {
+				e := f.addEdge(node(i, t, 1), node(index[next], t+1, 0), 1)
+				moves = append(moves, exactMove{edge: e, turn: t + 1, from: name, to: next})
+			}
+		}
+	}
+	f.addEdge(source, node(index[start], 0, 0), traincount)
+	for n := 0; n < traincount; n++ {
+		if !f.augment(source, sink) {
+			return nil, false
+		}
+	}
+	var used []exactMove
+	for _, m := range moves {
+		if f.capacity[m.edge] == 0 {
+			used = append(used, m)
+		}
+	}
+	return used, true
+}
+
+// exactMoves turns the flow back into trains. Trains are alike, so two trains
+// swapping places over a track are the same as both waiting, and such pairs
+// are dropped. The remaining arcs are given to the lowest numbered trains at
+// their stations.
+func exactMoves(moves []exactMove, start string, traincount, turns int) [][]Move {
+	taken := make(map[[2]string]map[int]bool)
+	for _, m := range moves {
+		key := [2]string{m.from, m.to}
+		if taken[key] == nil {
+			taken[key] = make(map[int]bool)
+		}
+		taken[key][m.turn] = true
+	}
+	location := make([]string, traincount)
+	for i := range location {
+		location[i] = start
+	}
+	schedule := make([][]Move, turns)
+	for t := 1; t <= turns; t++ {
+		moved := make([]bool, traincount)
+		for _, m := range moves {
+			if m.turn != t || taken[[2]string{m.to, m.from}][t] {
+				continue
+			}
+			for i := range location {
+				if location[i] == m.from && !moved[i] {
+					location[i] = m.to
+					moved[i] = true
+					schedule[t-1] = append(schedule[t-1], Move{Train: "T" + strconv.Itoa(i+1), Station: m.to})
+					break
+				}
+			}
+		}
+	}
+	// Turns at the end in which nothing moves are not needed.
+	for len(schedule) > 0 && len(schedule[len(schedule)-1]) == 0 {
+		schedule = schedule[:len(schedule)-1]
+	}
+	return schedule
+}
@@ -0,0 +1,87 @@
+package main
+
+import "testing"
+
+// fixtureRuns are the valid runs of run_tests.sh on maps small enough for the
+// exact solver.
+var fixtureRuns = []struct {
+	mapfile, start, end string
+	trains              int
+}{
+	{"maps/london.txt", "waterloo", "st_pancras", 1},
+	{"maps/london.txt", "waterloo", "st_pancras", 2},
+	{"maps/london.txt", "waterloo", "st_pancras", 3},
+	{"maps/london.txt", "waterloo", "st_pancras", 4},
+	{"maps/london.txt", "waterloo", "st_pancras", 100},
+	{"maps/beet.txt", "beethoven", "part", 9},
+	{"maps/sizes.txt", "small", "large", 9},
+	{"maps/numbers.txt", "two", "four", 4},
+	{"maps/jungle.txt", "jungle", "desert", 10},
+	{"maps/bond.txt", "bond_square", "space_port", 4},
+	{"maps/alpha.txt", "alpha", "zeta", 60},
+	{"maps/nu.txt", "alpha", "nu", 70},
+	{"maps/begi.txt", "beginning", "terminus", 20},
+}
+
+// compareWithExact checks the exact schedule against the movement rules and
+// returns how many turns the planner takes over it. The planner can never
+// beat the exact solver.
+func compareWithExact(t *testing.T, c randomCase) int {
+	t.Helper()
+	exact, err := exactSchedule(c.data.Stations, c.data.Connections, c.start, c.end, c.trains)
+	if err != nil {
+		t.Fatalf("exact: %s", err)
+	}
+	if err := checkSchedule(c.data.Connections, sameOrigin(c.trains, c.start), c.end, exact); err != nil {
+		t.Fatalf("exact schedule of %d trains from %s to %s breaks the rules: %s", c.trains, c.start, c.end, err)
+	}
+	_, planned, err := planAndSimulate(c)
+	if err != nil {
+		t.Fatalf("planner: %s", err)
+	}
+	if len(planned) < len(exact) {
+		t.Fatalf("%d trains from %s to %s: the planner took %d turns, fewer than the least possible %d", c.trains, c.start, c.end, len(planned), len(exact))
+	}
+	return len(planned) - len(exact)
+}
+
+func TestPlannerMatchesExactOnFixtures(t *testing.T) {
+	for _, run := range fixtureRuns {
+		data, err := loadMap([]string{run.mapfile}, ParseConfig{})
+		if err != nil || len(data.Errors) > 0 {
+			t.Fatalf("%s: %v %v", run.mapfile, err, data.Errors)
+		}
+		c := randomCase{data: data, start: run.start, end: run.end, trains: run.trains}
+		if gap := compareWithExact(t, c); gap != 0 {
+			t.Errorf("%s, %d trains from %s to %s: the planner took %d turns more than the least possible", run.mapfile, run.trains, run.start, run.end, gap)
+		}
+	}
+}
+
+// plannerBehind is how many of the generated maps the planner is known to
+// take more turns on than it has to. It should only ever go down.
+const plannerBehind = 1
+
+func TestPlannerAgainstExactOnGeneratedMaps(t *testing.T) {
+	behind := 0
+	for i, c := range randomCases(t, 300) {
+		if gap := compareWithExact(t, c); gap > 0 {
+			behind++
+			t.Logf("case %d, %d trains from %s to %s: %d turn(s) over the least possible", i, c.trains, c.start, c.end, gap)
+		}
+	}
+	if behind > plannerBehind {
+		t.Errorf("the planner took more turns than the least possible on %d of 300 maps, up from %d", behind, plannerBehind)
+	}
+}
+
+func TestExactScheduleRejectsLargeMaps(t *testing.T) {
+	stations := make(map[string]Station)
+	connections := make(map[string][]string)
+	for i := 0; i <= maxExactStations; i++ {
+		stations[string(rune('a'+i%26))+string(rune('a'+i/26))] = Station{X: i}
+	}
+	if _, err := exactSchedule(stations, connections, "aa", "ba", 1); err == nil {
+		t.Fatal("expected an error for a map over the size limit")
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// TraceEvent is one decision taken by the planner or Pathbuilder. Events that
+// happen while another is in progress (a Dijkstra run inside a replanning
+// round, say) are stored as its children.
+type TraceEvent struct {
+	Kind     string                 `json:"kind"`
+	Message  string                 `json:"message"`
+	Data     map[string]interface{} `json:"data,omitempty"`
+	Children []*TraceEvent          `json:"children,omitempty"`
+}
+
+// Explainer collects TraceEvents. A nil *Explainer records nothing, so callers
+// never have to check whether --explain was given.
+type Explainer struct {
+	events []*TraceEvent
+	stack  []*TraceEvent
+}
+
+func (e *Explainer) add(ev *TraceEvent) {
+	if len(e.stack) > 0 {
+		parent := e.stack[len(e.stack)-1]
+		parent.Children = append(parent.Children, ev)
+	} else {
+		e.events = append(e.events, ev)
+	}
+}
+
+// merge adds the events of another Explainer, one that recorded part of the
+// run on its own, at the current nesting level.
+func (e *Explainer) merge(other *Explainer) {
+	if e == nil || other == nil {
+		return
+	}
+	for _, ev := range other.events {
+		e.add(ev)
+	}
+}
+
+// note records a single event at the current nesting level.
+func (e *Explainer) note(kind string, data map[string]interface{}, format string, args ...interface{}) {
+	if e == nil {
+		return
+	}
+	e.add(&TraceEvent{Kind: kind, Message: fmt.Sprintf(format, args...), Data: data})
+}
+
+// begin records an event and nests every following event under it until end
+// is called.
+func (e *Explainer) begin(kind string, data map[string]interface{}, format string, args ...interface{}) {
+	if e == nil {
+		return
+	}
+	ev := &TraceEvent{Kind: kind, Message: fmt.Sprintf(format, args...), Data: data}
+	e.add(ev)
+	e.stack = append(e.stack, ev)
+}
+
+func (e *Explainer) end() {
+	if e == nil || len(e.stack) == 0 {
+		return
+	}
+	e.stack = e.stack[:len(e.stack)-1]
+}
+
+func (e *Explainer) WriteText(w io.Writer) {
+	var write func(events []*TraceEvent, depth int)
+	write = func(events []*TraceEvent, depth int) {
+		for _, ev := range events {
+			fmt.Fprintf(w, "%s%s: %s\n", strings.Repeat("  ", depth), ev.Kind, ev.Message)
+			write(ev.Children, depth+1)
+		}
+	}
+	write(e.events, 0)
+}
+
+func (e *Explainer) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	events := e.events
+	if events == nil {
+		events = []*TraceEvent{}
+	}
+	return enc.Encode(events)
+}
+
+// explainMode is the value of --explain. Given on its own it selects the text
+// trace, --explain=json selects JSON.
+type explainMode string
+
+func (m *explainMode) String() string { return string(*m) }
+
+func (m *explainMode) Set(value string) error {
+	switch value {
+	case "true", "text":
+		*m = "text"
+	case "json":
+		*m = "json"
+	case "false":
+		*m = ""
+	default:
+		return fmt.Errorf("unknown explain format %s, use text or json", value)
+	}
+	return nil
+}
+
+func (m *explainMode) IsBoolFlag() bool { return true }
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// The trace matters most when a run fails, so it has to be written on the
+// error paths too.
+func TestExplainWrittenWhenEndIsUnreachable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "split.txt")
+	text := "stations:\na,0,0\nb,1,0\nc,5,5\nd,6,5\nconnections:\na-b\nc-d\n"
+	if err := os.WriteFile(path, []byte(text), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	args, opts, err := parseCommandLine([]string{"--explain=json", path, "a", "d", "2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	run(args, opts, &out)
+	var events []TraceEvent
+	if err := json.Unmarshal(out.Bytes(), &events); err != nil {
+		t.Fatalf("trace is not JSON: %s\n%s", err, out.String())
+	}
+	if len(events) != 1 || events[0].Kind != "unreachable" || events[0].Data["end"] != "d" {
+		t.Fatalf("expected the trace to say d is unreachable, got %s", out.String())
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import "sort"
+
+// routeFlow is a unit-capacity flow network over the stations, used to find
+// routes that share no station other than their origins and the end. Every
+// station i is split into node 2i (entered) and 2i+1 (left), and a super
+// source feeds the origins, so routes from several depots can be planned at
+// once. Every track costs 1, which makes each augmentation add the shortest
+// possible route to the set (successive shortest paths). The first route out
+// of every origin gets a large bonus, so no origin is left without a route
+// while another one gets a second.
+type routeFlow struct {
+	names    []string
+	index    map[string]int
+	capacity []map[int]int
+	cost     []map[int]int
+	flow     []map[int]int
+	source   int
+	sink     int
+}
+
+// newRouteFlow builds the flow network. origins maps each origin station to
+// the largest number of routes that may leave it. Routes never pass through an
+// origin, so trains waiting there are not run into.
+func newRouteFlow(stations map[string]Station, connections map[string][]string, origins map[string]int, end string) *routeFlow {
+	names := make([]string, 0, len(stations))
+	for name := range stations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	f := &routeFlow{names: names, index: make(map[string]int, len(names))}
+	for i, name := range names {
+		f.index[name] = i
+	}
+	var sorted []string
+	for origin := range origins {
+		if _, ok := f.index[origin]; ok {
+			sorted = append(sorted, origin)
+		}
+	}
+	sort.Strings(sorted)
+	n := 2*len(names) + 1 + 2*len(sorted)
+	f.capacity = make([]map[int]int, n)
+	f.cost = make([]map[int]int, n)
+	f.flow = make([]map[int]int, n)
+	for i := 0; i < n; i++ {
+		f.capacity[i] = make(map[int]int)
+		f.cost[i] = make(map[int]int)
+		f.flow[i] = make(map[int]int)
+	}
+	f.source = 2 * len(names)
+	f.sink = 2 * f.index[end]
+
+	for i, name := range names {
+		c := 1
+		if name == end {
+			c = len(names)
+		}
+		if _, ok := origins[name]; ok {
+			c = 0
+		}
+		f.addEdge(2*i, 2*i+1, c, 0)
+	}
+	for _, a := range names {
+		for _, b := range connections[a] {
+			if j, ok := f.index[b]; ok && a != end {
+				f.addEdge(2*f.index[a]+1, 2*j, 1, 1)
+			}
+		}
+	}
+	bonus := -(len(names) + 1)
+	for k, origin := range sorted {
+		first, rest := f.source+1+2*k, f.source+2+2*k
+		out := 2*f.index[origin] + 1
+		f.addEdge(f.source, first, 1, bonus)
+		f.addEdge(first, out, 1, 0)
+		if origins[origin] > 1 {
+			f.addEdge(f.source, rest, origins[origin]-1, 0)
+			f.addEdge(rest, out, origins[origin]-1, 0)
+		}
+	}
+	return f
+}
+
+func (f *routeFlow) addEdge(u, v, capacity, cost int) {
+	f.capacity[u][v] = capacity
+	f.cost[u][v] = cost
+	if _, ok := f.capacity[v][u]; !ok {
+		// Residual edge for cancelling flow.
+		f.capacity[v][u] = 0
+		f.cost[v][u] = -cost
+	}
+}
+
+func sortedKeys(m map[int]int) []int {
+	keys := make([]int, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	return keys
+}
+
+// augment adds one more route along the cheapest augmenting path. It returns
+// false when no further route exists.
+func (f *routeFlow) augment() bool {
+	n := len(f.capacity)
+	const inf = int(^uint(0) >> 1)
+	dist := make([]int, n)
+	prev := make([]int, n)
+	inQueue := make([]bool, n)
+	for i := range dist {
+		dist[i] = inf
+		prev[i] = -1
+	}
+	dist[f.source] = 0
+	queue := []int{f.source}
+	inQueue[f.source] = true
+	for len(queue) > 0 {
+		u := queue[0]
+		queue = queue[1:]
+		inQueue[u] = false
+		for _, v := range sortedKeys(f.capacity[u]) {
+			if f.capacity[u][v]-f.flow[u][v] <= 0 {
+				continue
+			}
+			if d := dist[u] + f.cost[u][v]; d < dist[v] {
+				dist[v] = d
+				prev[v] = u
+				if !inQueue[v] {
+					inQueue[v] = true
+					queue = append(queue, v)
+				}
+			}
+		}
+	}
+	if dist[f.sink] == inf {
+		return false
+	}
+	for v := f.sink; v != f.source; v = prev[v] {
+		u := prev[v]
+		f.flow[u][v]++
+		f.flow[v][u]--
+	}
+	return true
+}
+
+// routes splits the current flow into routes in travelling order, shortest
+// first.
+func (f *routeFlow) routes() [][]string {
+	flow := make([]map[int]int, len(f.flow))
+	for i, m := range f.flow {
+		flow[i] = make(map[int]int, len(m))
+		for k, v := range m {
+			flow[i][k] = v
+		}
+	}
+	var routes [][]string
+	for {
+		var route []string
+		u := f.source
+		for u != f.sink {
+			next := -1
+			for _, v := range sortedKeys(flow[u]) {
+				if flow[u][v] > 0 {
+					next = v
+					break
+				}
+			}
+			if next == -1 {
+				break
+			}
+			flow[u][next]--
+			u = next
+			switch {
+			case u >= 2*len(f.names):
+				// Still between the super source and an origin.
+			case u%2 == 1:
+				route = []string{f.names[u/2]} // left an origin
+			default:
+				route = append(route, f.names[u/2])
+				if u != f.sink {
+					flow[u][u+1]--
+					u++
+				}
+			}
+		}
+		if u != f.sink {
+			break
+		}
+		routes = append(routes, route)
+	}
+	sort.SliceStable(routes, func(a, b int) bool { return len(routes[a]) < len(routes[b]) })
+	return routes
+}
+
+// disjointRoutes finds the largest set of routes from start to end that share
+// no station other than start and end. Routes are returned in travelling
+// order, shortest first.
+func disjointRoutes(stations map[string]Station, connections map[string][]string, start, end string) [][]string {
+	if !hasStation(stations, start) || !hasStation(stations, end) || start == end {
+		return nil
+	}
+	f := newRouteFlow(stations, connections, map[string]int{start: len(stations)}, end)
+	for f.augment() {
+	}
+	return f.routes()
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// FuzzParseMap feeds arbitrary text to the parser. It must never panic, and a
+// map it accepts without errors has to make sense.
+func FuzzParseMap(f *testing.F) {
+	files, _ := filepath.Glob("maps/*.txt")
+	for _, file := range files {
+		if content, err := os.ReadFile(file); err == nil {
+			f.Add(string(content))
+		}
+	}
+	f.Add("stations:\na,1,1\nb,2,2\nconnections:\na-b\n")
+	f.Add("stations:\na,1,1\na,1,1\nconnections:\na-a\n")
+	f.Add("include: other.txt\nstations:\nconnections:\n")
+	f.Add("coordinates: geo\ntolerance: 10\nstations:\na,-33.5,151.2\nb,-33.5001,151.2\nconnections:\na-b\n")
+	f.Add("stations:\na,1,1\nb,2,2\nc,3,3\nconnections:\na-b\nb-c\nlines:\nred,red,a,b,c\nnight,ff8800,c,b\n")
+
+	f.Fuzz(func(t *testing.T, text string) {
+		data := parseMap(strings.NewReader(text), ParseConfig{})
+		lines := strings.Count(text, "\n") + 1
+		for _, e := range data.Errors {
+			if e.Line < 0 || e.Line > lines {
+				t.Fatalf("error %q on line %d of a %d line map", e.Msg, e.Line, lines)
+			}
+		}
+		if len(data.Errors) > 0 {
+			return
+		}
+		if len(data.Stations) > defaultMaxStations {
+			t.Fatalf("%d stations accepted", len(data.Stations))
+		}
+		coords := make(map[Station]string)
+		for name, st := range data.Stations {
+			if st.Geo {
+				if st.Lat < -90 || st.Lat > 90 || st.Lon < -180 || st.Lon > 180 {
+					t.Fatalf("%s accepted at %s", name, st.Coords)
+				}
+				continue
+			}
+			if st.X < 0 || st.Y < 0 {
+				t.Fatalf("negative coordinates accepted for %s", name)
+			}
+			at := Station{X: st.X, Y: st.Y}
+			if other, taken := coords[at]; taken {
+				t.Fatalf("%s and %s accepted on the same coordinates", name, other)
+			}
+			coords[at] = name
+		}
+		for a, neighbors := range data.Connections {
+			for _, b := range neighbors {
+				if !hasStation(data.Stations, a) || !hasStation(data.Stations, b) {
+					t.Fatalf("connection %s-%s to an unknown station accepted", a, b)
+				}
+				if !contains(data.Connections[b], a) {
+					t.Fatalf("connection %s-%s only goes one way", a, b)
+				}
+				if _, ok := data.Tracks[trackKey(a, b)]; !ok {
+					t.Fatalf("connection %s-%s has no line", a, b)
+				}
+			}
+		}
+		for _, l := range data.Lines {
+			if _, ok := ansiColour(l.Colour); !ok {
+				t.Fatalf("line %s accepted with colour %s", l.Name, l.Colour)
+			}
+			for i := 1; i < len(l.Stations); i++ {
+				if !contains(data.Connections[l.Stations[i-1]], l.Stations[i]) {
+					t.Fatalf("line %s accepted from %s to %s without a track", l.Name, l.Stations[i-1], l.Stations[i])
+				}
+			}
+		}
+	})
+}
+
+// FuzzSchedule plans and simulates a random network for every seed and checks
+// the movement rules on the result.
+func FuzzSchedule(f *testing.F) {
+	for seed := int64(0); seed < 8; seed++ {
+		f.Add(seed, uint8(seed*3))
+	}
+	f.Fuzz(func(t *testing.T, seed int64, trains uint8) {
+		rng := rand.New(rand.NewSource(seed))
+		n := 2 + rng.Intn(20)
+		text := randomMap(rng, n)
+		c := randomCase{text: text, data: parseMap(strings.NewReader(text), ParseConfig{}),
+			start: "s0", end: fmt.Sprint("s", 1+rng.Intn(n-1)), trains: 1 + int(trains)%40}
+		_, turns, err := planAndSimulate(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := checkSchedule(c.data.Connections, sameOrigin(c.trains, c.start), c.end, turns); err != nil {
+			t.Fatalf("%d trains from %s to %s: %s\n%s", c.trains, c.start, c.end, err, text)
+		}
+	})
+}
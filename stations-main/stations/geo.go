@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// earthRadius is the mean radius of the earth in metres.
+const earthRadius = 6371000.0
+
+// haversine returns the great-circle distance in metres between two points
+// given in decimal degrees.
+func haversine(lat1, lon1, lat2, lon2 float64) float64 {
+	rad := math.Pi / 180
+	dLat := (lat2 - lat1) * rad
+	dLon := (lon2 - lon1) * rad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// geoReach returns how many degrees of latitude and of longitude a point can
+// be away from a point at latitude lat and still be within r metres of it. The
+// longitude is 180 when the circle of r metres takes in a pole.
+func geoReach(lat, r float64) (dLat, dLon float64) {
+	d := r / earthRadius // the radius as an angle at the centre of the earth
+	dLat = d * 180 / math.Pi
+	if s := math.Sin(d) / math.Cos(lat*math.Pi/180); d < math.Pi/2 && s < 1 {
+		return dLat, math.Asin(s) * 180 / math.Pi
+	}
+	return dLat, 180
+}
+
+// parseGeoStation reads a latitude and longitude in decimal degrees. Negative
+// values are south and west.
+func parseGeoStation(name, lat, lon string) (Station, error) {
+	st := Station{Name: name, Geo: true, Coords: lat + "," + lon}
+	var errLat, errLon error
+	st.Lat, errLat = strconv.ParseFloat(lat, 64)
+	st.Lon, errLon = strconv.ParseFloat(lon, 64)
+	if errLat != nil || errLon != nil || math.IsNaN(st.Lat) || math.IsNaN(st.Lon) {
+		return st, fmt.Errorf("Station %s has coordinates %s,%s which are not decimal degrees", name, lat, lon)
+	}
+	if st.Lat < -90 || st.Lat > 90 || st.Lon < -180 || st.Lon > 180 {
+		return st, fmt.Errorf("Station %s has coordinates %s,%s outside latitude -90 to 90 and longitude -180 to 180", name, lat, lon)
+	}
+	return st, nil
+}
+
+// samePlace reports whether two definitions of a station agree on where it is.
+func samePlace(a, b Station) bool {
+	if a.Geo || b.Geo {
+		return a.Geo == b.Geo && a.Lat == b.Lat && a.Lon == b.Lon
+	}
+	return a.X == b.X && a.Y == b.Y
+}
+
+// geoGrid finds geo stations closer together than a tolerance. Stations are
+// kept in bands of latitude as wide as the tolerance, so only the band of a
+// station and its two neighbours have to be searched.
+type geoGrid struct {
+	tolerance float64
+	band      float64 // degrees of latitude per band
+	bands     map[int][]Station
+}
+
+func newGeoGrid(tolerance float64) *geoGrid {
+	g := &geoGrid{tolerance: tolerance, bands: make(map[int][]Station)}
+	// A degree of latitude is the same length everywhere.
+	g.band = tolerance / (earthRadius * math.Pi / 180)
+	return g
+}
+
+func (g *geoGrid) bandOf(lat float64) int {
+	if g.band == 0 {
+		return int(math.Floor(lat * 1e6))
+	}
+	return int(math.Floor(lat / g.band))
+}
+
+// near returns a station within the tolerance of st, if there is one. With no
+// tolerance only stations on exactly the same coordinates clash.
+func (g *geoGrid) near(st Station) (Station, bool) {
+	b := g.bandOf(st.Lat)
+	for _, band := range []int{b - 1, b, b + 1} {
+		for _, other := range g.bands[band] {
+			if g.tolerance == 0 && other.Lat == st.Lat && other.Lon == st.Lon ||
+				g.tolerance > 0 && haversine(st.Lat, st.Lon, other.Lat, other.Lon) <= g.tolerance {
+				return other, true
+			}
+		}
+	}
+	return Station{}, false
+}
+
+func (g *geoGrid) add(st Station) {
+	b := g.bandOf(st.Lat)
+	g.bands[b] = append(g.bands[b], st)
+}
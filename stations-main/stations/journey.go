@@ -0,0 +1,232 @@
+package main
+
+import (
+	"container/heap"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Leg is the part of a journey spent on one line, from the station where the
+// passenger gets on to the one where they get off.
+type Leg struct {
+	Line     string
+	Stations []string
+}
+
+// Itinerary is a passenger journey. A transfer happens at the last station
+// of every leg but the final one.
+type Itinerary struct {
+	Legs      []Leg
+	Transfers int
+	Stops     int
+	Distance  int
+}
+
+// journeyObjectives are the values of --objective, each the order in which
+// transfers, stops and distance are compared.
+var journeyObjectives = map[string][3]int{
+	"transfers": {0, 1, 2},
+	"stops":     {1, 0, 2},
+	"distance":  {2, 0, 1},
+}
+
+// rideState is a passenger at a station on a line.
+type rideState struct {
+	station, line string
+}
+
+type rideItem struct {
+	state rideState
+	cost  [3]int // transfers, stops, distance
+	key   [3]int // cost in the order of the objective
+}
+
+type rideQueue []rideItem
+
+func (q rideQueue) Len() int { return len(q) }
+
+func (q rideQueue) Less(a, b int) bool {
+	for i := range q[a].key {
+		if q[a].key[i] != q[b].key[i] {
+			return q[a].key[i] < q[b].key[i]
+		}
+	}
+	// Equal journeys are told apart by name, so the answer does not depend
+	// on map order.
+	if q[a].state.station != q[b].state.station {
+		return q[a].state.station < q[b].state.station
+	}
+	return q[a].state.line < q[b].state.line
+}
+
+func (q rideQueue) Swap(a, b int) { q[a], q[b] = q[b], q[a] }
+
+func (q *rideQueue) Push(x interface{}) { *q = append(*q, x.(rideItem)) }
+
+func (q *rideQueue) Pop() interface{} {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
+
+// lineNeighbours lists, for every line, the stations next to each station.
+func lineNeighbours(lines []MapLine) map[string]map[string][]string {
+	next := make(map[string]map[string][]string, len(lines))
+	for _, l := range lines {
+		next[l.Name] = make(map[string][]string)
+		for i := 1; i < len(l.Stations); i++ {
+			a, b := l.Stations[i-1], l.Stations[i]
+			if !contains(next[l.Name][a], b) {
+				next[l.Name][a] = append(next[l.Name][a], b)
+				next[l.Name][b] = append(next[l.Name][b], a)
+			}
+		}
+	}
+	return next
+}
+
+// planJourney finds the best journey from one station to another riding the
+// lines of the map, in either direction. objective is one of the keys of
+// journeyObjectives; what it leaves open is settled by the other two measures
+// in the order given there.
+func planJourney(stations map[string]Station, lines []MapLine, from, to, objective string) (*Itinerary, error) {
+	order, ok := journeyObjectives[objective]
+	if !ok {
+		return nil, fmt.Errorf("unknown objective %s, use transfers, stops or distance", objective)
+	}
+	if from == to {
+		return nil, fmt.Errorf("Start and end stations are same (%s)", from)
+	}
+	next := lineNeighbours(lines)
+	servedBy := make(map[string][]string)
+	for _, l := range lines {
+		for station := range next[l.Name] {
+			if !contains(servedBy[station], l.Name) {
+				servedBy[station] = append(servedBy[station], l.Name)
+			}
+		}
+	}
+	for _, station := range []string{from, to} {
+		if !hasStation(stations, station) {
+			return nil, fmt.Errorf("station %s does not exist", station)
+		}
+		if len(servedBy[station]) == 0 {
+			return nil, fmt.Errorf("no line calls at %s", station)
+		}
+	}
+
+	best := make(map[rideState][3]int)
+	prev := make(map[rideState]rideState)
+	done := make(map[rideState]bool)
+	queue := &rideQueue{}
+	push := func(state rideState, cost [3]int, from *rideState) {
+		if old, seen := best[state]; seen && !lessCost(cost, old, order) {
+			return
+		}
+		best[state] = cost
+		if from != nil {
+			prev[state] = *from
+		} else {
+			delete(prev, state)
+		}
+		heap.Push(queue, rideItem{state: state, cost: cost, key: [3]int{cost[order[0]], cost[order[1]], cost[order[2]]}})
+	}
+	for _, line := range servedBy[from] {
+		push(rideState{from, line}, [3]int{}, nil)
+	}
+	for queue.Len() > 0 {
+		item := heap.Pop(queue).(rideItem)
+		at := item.state
+		if done[at] {
+			continue
+		}
+		done[at] = true
+		if at.station == to {
+			return buildItinerary(at, prev, item.cost), nil
+		}
+		for _, station := range next[at.line][at.station] {
+			cost := item.cost
+			cost[1]++
+			cost[2] += distance(stations[at.station], stations[station])
+			push(rideState{station, at.line}, cost, &at)
+		}
+		for _, line := range servedBy[at.station] {
+			if line != at.line {
+				cost := item.cost
+				cost[0]++
+				push(rideState{at.station, line}, cost, &at)
+			}
+		}
+	}
+	return nil, fmt.Errorf("no journey from %s to %s on the lines of the map", from, to)
+}
+
+func lessCost(a, b [3]int, order [3]int) bool {
+	for _, i := range order {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return false
+}
+
+// buildItinerary follows the states back from the destination and groups
+// them into legs.
+func buildItinerary(end rideState, prev map[rideState]rideState, cost [3]int) *Itinerary {
+	var states []rideState
+	for at, ok := end, true; ok; at, ok = prev[at] {
+		states = append(states, at)
+	}
+	it := &Itinerary{Transfers: cost[0], Stops: cost[1], Distance: cost[2]}
+	for i := len(states) - 1; i >= 0; i-- {
+		s := states[i]
+		if n := len(it.Legs); n == 0 || it.Legs[n-1].Line != s.line {
+			it.Legs = append(it.Legs, Leg{Line: s.line})
+		}
+		leg := &it.Legs[len(it.Legs)-1]
+		if k := len(leg.Stations); k == 0 || leg.Stations[k-1] != s.station {
+			leg.Stations = append(leg.Stations, s.station)
+		}
+	}
+	return it
+}
+
+func (it *Itinerary) Write(w io.Writer) {
+	fmt.Fprintf(w, "%d transfer(s), %d stop(s), distance %d\n", it.Transfers, it.Stops, it.Distance)
+	for i, leg := range it.Legs {
+		fmt.Fprintf(w, "  %s: %s (%d stop(s))\n", leg.Line, strings.Join(leg.Stations, " -> "), len(leg.Stations)-1)
+		if i+1 < len(it.Legs) {
+			fmt.Fprintf(w, "  change at %s to %s\n", leg.Stations[len(leg.Stations)-1], it.Legs[i+1].Line)
+		}
+	}
+}
+
+func journeyMain(args []string, opts *options) {
+	if len(args) != 3 {
+		fmt.Fprintf(os.Stderr, "Error: incorrect number of arguments for journey\n")
+		fmt.Println(Green, " To plan a passenger journey:")
+		fmt.Println("  go run . journey [--objective transfers|stops|distance] <path to file containing network map> <from station> <to station>", Reset)
+		os.Exit(0)
+	}
+	network, err := loadNetwork(args[0], opts.parse)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Println(Red, "Please fix listed errors in", args[0], Reset)
+		os.Exit(0)
+	}
+	if len(network.Lines) == 0 {
+		fmt.Fprintf(os.Stderr, "Error: %s has no lines section, journeys run on lines\n", args[0])
+		os.Exit(0)
+	}
+	it, err := planJourney(network.Stations, network.Lines, args[1], args[2], opts.objective)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		os.Exit(0)
+	}
+	goal := map[string]string{"transfers": "fewest transfers", "stops": "fewest stops", "distance": "shortest distance"}[opts.objective]
+	fmt.Printf("Journey from %s to %s with the %s:\n", args[1], args[2], goal)
+	it.Write(os.Stdout)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// On this map the slow line calls everywhere, while two short lines get
+// across with a change at x, which is a long way round.
+const journeyMap = `stations:
+a,0,0
+b,1,0
+c,2,0
+d,3,0
+e,4,0
+x,2,5
+connections:
+a-b
+b-c
+c-d
+d-e
+a-x
+x-e
+lines:
+slow,green,a,b,c,d,e
+cross,red,a,x
+east,blue,x,e
+`
+
+func TestJourneyObjectives(t *testing.T) {
+	data := parseMap(strings.NewReader(journeyMap), ParseConfig{})
+	if len(data.Errors) > 0 {
+		t.Fatal(data.Errors)
+	}
+	slow := []Leg{{Line: "slow", Stations: []string{"a", "b", "c", "d", "e"}}}
+	across := []Leg{{Line: "cross", Stations: []string{"a", "x"}}, {Line: "east", Stations: []string{"x", "e"}}}
+	for _, c := range []struct {
+		objective string
+		legs      []Leg
+		transfers int
+	}{
+		{"transfers", slow, 0},
+		{"stops", across, 1},
+		{"distance", slow, 0},
+	} {
+		it, err := planJourney(data.Stations, data.Lines, "a", "e", c.objective)
+		if err != nil {
+			t.Fatalf("%s: %s", c.objective, err)
+		}
+		if !reflect.DeepEqual(it.Legs, c.legs) || it.Transfers != c.transfers {
+			t.Fatalf("%s: got %+v with %d transfers, want %+v", c.objective, it.Legs, it.Transfers, c.legs)
+		}
+	}
+	if it, err := planJourney(data.Stations, data.Lines, "e", "a", "stops"); err != nil || it.Legs[0].Line != "east" {
+		t.Fatalf("lines should run both ways, got %+v, %v", it, err)
+	}
+	if _, err := planJourney(data.Stations, nil, "a", "e", "stops"); err == nil {
+		t.Fatal("journey found without lines")
+	}
+}
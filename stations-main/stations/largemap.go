@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"runtime"
+	"strconv"
+	"time"
+)
+
+// writeGridMap writes a network of n stations laid out on a square grid, each
+// connected to its neighbours to the right and below, to try the tool on maps
+// far larger than the ones in maps/. Stations are named s0, s1 and so on, row
+// by row.
+func writeGridMap(w io.Writer, n int) error {
+	width := int(math.Ceil(math.Sqrt(float64(n))))
+	out := bufio.NewWriter(w)
+	fmt.Fprintln(out, "stations:")
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(out, "s%d,%d,%d\n", i, i%width, i/width)
+	}
+	fmt.Fprintln(out, "connections:")
+	for i := 0; i < n; i++ {
+		if i%width+1 < width && i+1 < n {
+			fmt.Fprintf(out, "s%d-s%d\n", i, i+1)
+		}
+		if i+width < n {
+			fmt.Fprintf(out, "s%d-s%d\n", i, i+width)
+		}
+	}
+	return out.Flush()
+}
+
+// writeMemory prints what --memory reports: the size of the network, how
+// long loading it took and the memory it holds.
+func writeMemory(w io.Writer, stations map[string]Station, connections map[string][]string, took time.Duration) {
+	tracks := 0
+	for _, next := range connections {
+		tracks += len(next)
+	}
+	runtime.GC()
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	fmt.Fprintf(w, "Loaded %d stations and %d tracks in %s, %s in use, %s allocated\n",
+		len(stations), tracks/2, took.Round(time.Millisecond), formatBytes(mem.HeapAlloc), formatBytes(mem.TotalAlloc))
+}
+
+func generateMain(args []string) {
+	if len(args) != 2 {
+		fmt.Fprintf(os.Stderr, "Error: incorrect number of arguments for generate\n")
+		fmt.Println(Green, " To generate a grid map:")
+		fmt.Println("  go run . generate <numeric amount of stations> <path to the map file to write>", Reset)
+		os.Exit(0)
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n < 2 {
+		fmt.Fprintf(os.Stderr, "Error: unable to convert stations(%s) to an integer of at least 2\n", args[0])
+		os.Exit(0)
+	}
+	file, err := os.Create(args[1])
+	if err == nil {
+		err = writeGridMap(file, n)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		os.Exit(0)
+	}
+	width := int(math.Ceil(math.Sqrt(float64(n))))
+	fmt.Printf("Wrote %d stations on a %d wide grid to %s, from s0 to s%d\n", n, width, args[1], n-1)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGridMapParses(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeGridMap(&buf, 10); err != nil {
+		t.Fatal(err)
+	}
+	data := parseMap(&buf, ParseConfig{})
+	if len(data.Errors) > 0 {
+		t.Fatalf("generated map has errors: %v", data.Errors)
+	}
+	// 10 stations on a 4 wide grid: rows of 4, 4 and 2.
+	if len(data.Stations) != 10 || len(data.Tracks) != 13 {
+		t.Fatalf("got %d stations and %d tracks, want 10 and 13", len(data.Stations), len(data.Tracks))
+	}
+	if !reachable(data.Stations, data.Connections, "s0", "s9") {
+		t.Fatal("s9 cannot be reached from s0")
+	}
+}
+
+func TestStationLimitIsConfigurable(t *testing.T) {
+	var buf bytes.Buffer
+	writeGridMap(&buf, 1001)
+	text := buf.String()
+
+	data := parseMap(strings.NewReader(text), ParseConfig{MaxStations: 1000})
+	if !data.TooLarge || len(data.Errors) == 0 || !strings.Contains(data.Errors[0].Msg, "maximum number(1,000)") {
+		t.Fatalf("1001 stations accepted with a limit of 1000: %v", data.Errors)
+	}
+	if data := parseMap(strings.NewReader(text), ParseConfig{MaxStations: 1001}); data.TooLarge || len(data.Errors) > 0 {
+		t.Fatalf("1001 stations rejected with a limit of 1001: %v", data.Errors)
+	}
+	if data := parseMap(strings.NewReader(text), ParseConfig{}); data.TooLarge || len(data.Errors) > 0 {
+		t.Fatalf("1001 stations rejected with the default limit: %v", data.Errors)
+	}
+}
+
+// Files within the limit on their own may still make a network too large.
+func TestStationLimitCountsEveryFile(t *testing.T) {
+	files := map[string]string{
+		"a.txt": "include: b.txt\nstations:\na,0,0\nb,1,0\nconnections:\na-b\n",
+		"b.txt": "stations:\nc,2,0\nd,3,0\nconnections:\nb-c\nc-d\n",
+	}
+	read := func(path string) ([]byte, error) { return []byte(files[path]), nil }
+	data, err := loadMapFrom([]string{"a.txt"}, ParseConfig{MaxStations: 3}, read)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !data.TooLarge || len(data.Errors) != 1 || !strings.Contains(data.Errors[0].Msg, "maximum number(3)") {
+		t.Fatalf("4 stations in two files accepted with a limit of 3: %v", data.Errors)
+	}
+	if data, _ := loadMapFrom([]string{"a.txt"}, ParseConfig{MaxStations: 4}, read); data.TooLarge || len(data.Errors) > 0 {
+		t.Fatalf("4 stations in two files rejected with a limit of 4: %v", data.Errors)
+	}
+}
+
+// BenchmarkParseLargeMap parses a grid of a million stations, the size
+// --max-stations is there for. Run it once, it takes seconds:
+//
+//	go test -run XXX -bench ParseLargeMap -benchtime 1x
+//
+// It fails when a parse takes longer than parseBudget. A laptop parses the
+// grid in about a second; the budget leaves room for slow shared machines
+// but not for the parser getting several times slower.
+func BenchmarkParseLargeMap(b *testing.B) {
+	const stations = 1000000
+	const parseBudget = 15 * time.Second
+	var buf bytes.Buffer
+	writeGridMap(&buf, stations)
+	text := buf.Bytes()
+	b.SetBytes(int64(len(text)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		data := parseMap(bytes.NewReader(text), ParseConfig{MaxStations: stations})
+		if len(data.Errors) > 0 || len(data.Stations) != stations {
+			b.Fatalf("got %d stations, errors %v", len(data.Stations), data.Errors)
+		}
+	}
+	if took := b.Elapsed() / time.Duration(b.N); took > parseBudget {
+		b.Fatalf("parsing %d stations took %s, more than the budget of %s", stations, took, parseBudget)
+	}
+}
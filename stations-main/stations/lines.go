@@ -0,0 +1,253 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MapLine is a line from the lines section of a map, such as
+//
+//	lines:
+//	red,red,waterloo,victoria,st_pancras
+//
+// with a name, a colour and the stations it calls at in order. Every two
+// stations next to each other need a track between them.
+type MapLine struct {
+	Name     string
+	Colour   string // as written in the map
+	Stations []string
+	Line     int // line of the map file it is defined on
+}
+
+// ansiColours are the colour names a line may use.
+var ansiColours = map[string]string{
+	"red":     "\033[1;31m",
+	"green":   "\033[1;32m",
+	"yellow":  "\033[1;33m",
+	"blue":    "\033[1;34m",
+	"magenta": "\033[1;35m",
+	"cyan":    "\033[1;36m",
+	"white":   "\033[1;37m",
+}
+
+var hexColour = regexp.MustCompile(`^[0-9a-f]{6}$`)
+
+// ansiColour turns the colour of a line into a terminal escape code. Besides
+// the names in ansiColours it takes six hex digits such as ff8800; there is no
+// leading # as that starts a comment in a map.
+func ansiColour(colour string) (string, bool) {
+	if code, ok := ansiColours[colour]; ok {
+		return code, true
+	}
+	if !hexColour.MatchString(colour) {
+		return "", false
+	}
+	rgb, _ := strconv.ParseUint(colour, 16, 32)
+	return fmt.Sprintf("\033[1;38;2;%d;%d;%dm", rgb>>16, rgb>>8&0xff, rgb&0xff), true
+}
+
+// parseLine reads one line of the lines section. It reports what is wrong
+// with the line itself; its stations and tracks are checked by checkLines
+// once the whole network is known.
+func parseLine(text string, lineNo int, report func(format string, args ...interface{})) (MapLine, bool) {
+	parts := strings.Split(text, ",")
+	if len(parts) < 4 {
+		report("Line %s should be given as name,colour,station,station[,station]...", parts[0])
+		return MapLine{}, false
+	}
+	line := MapLine{Name: parts[0], Colour: parts[1], Stations: parts[2:], Line: lineNo}
+	if !validStationName(line.Name) {
+		report("Line (%s) should be composed by only lowercase, numbers and underscore characters", line.Name)
+	}
+	if _, ok := ansiColour(line.Colour); !ok {
+		report("Line %s has colour %s, use a colour name or six hex digits such as ff8800", line.Name, line.Colour)
+	}
+	return line, true
+}
+
+// checkLines reports lines defined twice, lines calling at stations that do
+// not exist and lines running where there is no track.
+func checkLines(lines []MapLine, stations map[string]Station, connections map[string][]string, report func(line int, format string, args ...interface{})) {
+	defined := make(map[string]bool)
+	for _, l := range lines {
+		if defined[l.Name] {
+			report(l.Line, "Line %s defined more than once", l.Name)
+		}
+		defined[l.Name] = true
+		for i, station := range l.Stations {
+			if !hasStation(stations, station) {
+				report(l.Line, "Line %s calls at %s, which is not specified within stations section", l.Name, station)
+				continue
+			}
+			if i > 0 && hasStation(stations, l.Stations[i-1]) && !contains(connections[l.Stations[i-1]], station) {
+				report(l.Line, "Line %s runs from %s to %s, which are not connected", l.Name, l.Stations[i-1], station)
+			}
+		}
+	}
+}
+
+func findLine(lines []MapLine, name string) (MapLine, bool) {
+	for _, l := range lines {
+		if l.Name == name {
+			return l, true
+		}
+	}
+	return MapLine{}, false
+}
+
+// tracks returns the tracks the line runs on, keyed by trackKey.
+func (l MapLine) tracks() map[string]bool {
+	tracks := make(map[string]bool, len(l.Stations))
+	for i := 1; i < len(l.Stations); i++ {
+		tracks[trackKey(l.Stations[i-1], l.Stations[i])] = true
+	}
+	return tracks
+}
+
+// route returns the stations a train on the line calls at from one station to
+// another, in whichever direction it has to run, or nil when the line does not
+// call at both.
+func (l MapLine) route(from, to string) []string {
+	i, j := -1, -1
+	for k, station := range l.Stations {
+		if station == from && i == -1 {
+			i = k
+		}
+		if station == to && j == -1 {
+			j = k
+		}
+	}
+	if i == -1 || j == -1 || i == j {
+		return nil
+	}
+	if i < j {
+		return append([]string(nil), l.Stations[i:j+1]...)
+	}
+	return forward(l.Stations[j : i+1])
+}
+
+// applyLines puts trains on the lines given with --line train:line. The train
+// only runs on the tracks of its line, so the route of the line from start to
+// end is returned to be offered to the trains alongside the planned ones.
+func applyLines(trains []*Train, lines []MapLine, assignments []string, start, end string) ([][]string, error) {
+	byName := make(map[string]*Train, len(trains))
+	for _, t := range trains {
+		byName[t.Name] = t
+	}
+	var routes [][]string
+	added := make(map[string]bool)
+	for _, value := range assignments {
+		name, lineName, ok := strings.Cut(value, ":")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("(%s) should be given as train:line", value)
+		}
+		t, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("train %s is not one of the %d trains (T1 to T%d)", name, len(trains), len(trains))
+		}
+		line, ok := findLine(lines, lineName)
+		if !ok {
+			if len(lines) == 0 {
+				return nil, fmt.Errorf("line %s is not defined, the map has no lines section", lineName)
+			}
+			return nil, fmt.Errorf("line %s is not defined, the map has %s", lineName, strings.Join(lineNames(lines), ", "))
+		}
+		route := line.route(start, end)
+		if route == nil {
+			return nil, fmt.Errorf("line %s does not call at both %s and %s", lineName, start, end)
+		}
+		t.Line = line.Name
+		t.Tracks = line.tracks()
+		if !added[line.Name] {
+			added[line.Name] = true
+			routes = append(routes, route)
+		}
+	}
+	return routes, nil
+}
+
+// turnPainter colours the moves of a run by line: a train on a line in the
+// colour of its line, any other train in the colour of the first line that
+// runs on the track it takes, and Blue where no line runs.
+type turnPainter struct {
+	train map[string]string // colour of each train on a line
+	track map[string]string // colour of each track on a line
+	at    map[string]string // where each train is
+}
+
+// newTurnPainter returns nil when the map has no lines, which prints every
+// turn in Blue as before.
+func newTurnPainter(lines []MapLine, origins map[string]string, trainLines map[string]string) *turnPainter {
+	if len(lines) == 0 {
+		return nil
+	}
+	p := &turnPainter{train: make(map[string]string), track: make(map[string]string), at: make(map[string]string, len(origins))}
+	for train, origin := range origins {
+		p.at[train] = origin
+	}
+	for _, l := range lines {
+		code, _ := ansiColour(l.Colour)
+		for track := range l.tracks() {
+			if _, taken := p.track[track]; !taken {
+				p.track[track] = code
+			}
+		}
+	}
+	for train, name := range trainLines {
+		if l, ok := findLine(lines, name); ok {
+			p.train[train], _ = ansiColour(l.Colour)
+		}
+	}
+	return p
+}
+
+func (p *turnPainter) format(turn []Move) string {
+	var b strings.Builder
+	for _, move := range turn {
+		code, ok := p.train[move.Train]
+		if !ok {
+			code, ok = p.track[trackKey(p.at[move.Train], move.Station)]
+		}
+		if !ok {
+			code = Blue
+		}
+		p.at[move.Train] = move.Station
+		b.WriteString(code + move.Train + "-" + move.Station + " ")
+	}
+	return b.String()
+}
+
+// printTurns prints every turn, in line colours when there is a painter.
+func printTurns(turns [][]Move, paint *turnPainter) {
+	for _, turn := range turns {
+		if paint == nil {
+			fmt.Println(Blue, formatTurn(turn), Reset)
+		} else {
+			fmt.Println(Blue, paint.format(turn), Reset)
+		}
+	}
+}
+
+// trainLines lists the line of every train that runs on one.
+func trainLines(trains []*Train) map[string]string {
+	result := make(map[string]string)
+	for _, t := range trains {
+		if t.Line != "" {
+			result[t.Name] = t.Line
+		}
+	}
+	return result
+}
+
+// lineNames lists the names of the lines, sorted.
+func lineNames(lines []MapLine) []string {
+	names := make([]string, len(lines))
+	for i, l := range lines {
+		names[i] = l.Name
+	}
+	sort.Strings(names)
+	return names
+}
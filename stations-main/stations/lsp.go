@@ -0,0 +1,526 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The language server speaks JSON-RPC over stdin/stdout, as described in the
+// Language Server Protocol. Only full document sync is supported, which is
+// plenty for map files.
+
+type rpcMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type lspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type lspRange struct {
+	Start lspPosition `json:"start"`
+	End   lspPosition `json:"end"`
+}
+
+type lspLocation struct {
+	URI   string   `json:"uri"`
+	Range lspRange `json:"range"`
+}
+
+type lspTextEdit struct {
+	Range   lspRange `json:"range"`
+	NewText string   `json:"newText"`
+}
+
+type lspDiagnostic struct {
+	Range    lspRange `json:"range"`
+	Severity int      `json:"severity"`
+	Source   string   `json:"source"`
+	Message  string   `json:"message"`
+}
+
+type textDocumentPosition struct {
+	TextDocument struct {
+		URI string `json:"uri"`
+	} `json:"textDocument"`
+	Position lspPosition `json:"position"`
+}
+
+// mapToken is one occurrence of a station name in a map document.
+type mapToken struct {
+	Name string
+	Line int // 0-based, as in LSP
+	Col  int
+	Def  bool // true for the name in the stations section
+}
+
+type lspServer struct {
+	in    *bufio.Reader
+	out   io.Writer
+	docs  map[string]string
+	parse ParseConfig
+}
+
+func runLanguageServer(in io.Reader, out io.Writer, cfg ParseConfig) error {
+	s := &lspServer{in: bufio.NewReader(in), out: out, docs: make(map[string]string), parse: cfg}
+	for {
+		msg, err := s.read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if msg.Method == "exit" {
+			return nil
+		}
+		s.handle(msg)
+	}
+}
+
+func (s *lspServer) read() (*rpcMessage, error) {
+	length := -1
+	for {
+		header, err := s.in.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		header = strings.TrimSpace(header)
+		if header == "" {
+			break
+		}
+		if strings.HasPrefix(strings.ToLower(header), "content-length:") {
+			length, err = strconv.Atoi(strings.TrimSpace(header[len("content-length:"):]))
+			if err != nil {
+				return nil, fmt.Errorf("bad Content-Length header: %s", header)
+			}
+		}
+	}
+	if length < 0 {
+		return nil, fmt.Errorf("missing Content-Length header")
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(s.in, body); err != nil {
+		return nil, err
+	}
+	msg := &rpcMessage{}
+	if err := json.Unmarshal(body, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func (s *lspServer) write(msg *rpcMessage) {
+	msg.JSONRPC = "2.0"
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n%s", len(body), body)
+}
+
+func (s *lspServer) reply(id json.RawMessage, result interface{}) {
+	if result == nil {
+		// LSP expects an explicit null rather than a missing result.
+		result = json.RawMessage("null")
+	}
+	s.write(&rpcMessage{ID: id, Result: result})
+}
+
+func (s *lspServer) handle(msg *rpcMessage) {
+	switch msg.Method {
+	case "initialize":
+		s.reply(msg.ID, map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync":   1,
+				"completionProvider": map[string]interface{}{},
+				"definitionProvider": true,
+				"renameProvider":     true,
+				"hoverProvider":      true,
+			},
+			"serverInfo": map[string]string{"name": "stations"},
+		})
+	case "shutdown":
+		s.reply(msg.ID, nil)
+	case "textDocument/didOpen":
+		var p struct {
+			TextDocument struct {
+				URI  string `json:"uri"`
+				Text string `json:"text"`
+			} `json:"textDocument"`
+		}
+		if json.Unmarshal(msg.Params, &p) == nil {
+			s.docs[p.TextDocument.URI] = p.TextDocument.Text
+			s.publishDiagnostics(p.TextDocument.URI)
+		}
+	case "textDocument/didChange":
+		var p struct {
+			TextDocument struct {
+				URI string `json:"uri"`
+			} `json:"textDocument"`
+			ContentChanges []struct {
+				Text string `json:"text"`
+			} `json:"contentChanges"`
+		}
+		if json.Unmarshal(msg.Params, &p) == nil && len(p.ContentChanges) > 0 {
+			s.docs[p.TextDocument.URI] = p.ContentChanges[len(p.ContentChanges)-1].Text
+			s.publishDiagnostics(p.TextDocument.URI)
+		}
+	case "textDocument/didClose":
+		var p struct {
+			TextDocument struct {
+				URI string `json:"uri"`
+			} `json:"textDocument"`
+		}
+		if json.Unmarshal(msg.Params, &p) == nil {
+			delete(s.docs, p.TextDocument.URI)
+			s.write(&rpcMessage{Method: "textDocument/publishDiagnostics", Params: mustJSON(map[string]interface{}{
+				"uri": p.TextDocument.URI, "diagnostics": []lspDiagnostic{},
+			})})
+		}
+	case "textDocument/completion":
+		var p textDocumentPosition
+		if json.Unmarshal(msg.Params, &p) != nil {
+			s.reply(msg.ID, nil)
+			return
+		}
+		s.reply(msg.ID, s.completion(p))
+	case "textDocument/definition":
+		var p textDocumentPosition
+		if json.Unmarshal(msg.Params, &p) != nil {
+			s.reply(msg.ID, nil)
+			return
+		}
+		s.reply(msg.ID, s.definition(p))
+	case "textDocument/hover":
+		var p textDocumentPosition
+		if json.Unmarshal(msg.Params, &p) != nil {
+			s.reply(msg.ID, nil)
+			return
+		}
+		s.reply(msg.ID, s.hover(p))
+	case "textDocument/rename":
+		var p struct {
+			textDocumentPosition
+			NewName string `json:"newName"`
+		}
+		if json.Unmarshal(msg.Params, &p) != nil {
+			s.reply(msg.ID, nil)
+			return
+		}
+		if !validStationName(p.NewName) {
+			s.write(&rpcMessage{ID: msg.ID, Error: &rpcError{Code: -32602,
+				Message: fmt.Sprintf("Station (%s) should be composed by only lowercase, numbers and underscore characters", p.NewName)}})
+			return
+		}
+		result, err := s.rename(p.textDocumentPosition, p.NewName)
+		if err != nil {
+			s.write(&rpcMessage{ID: msg.ID, Error: err})
+			return
+		}
+		s.reply(msg.ID, result)
+	default:
+		// Requests we do not know must be answered, notifications ignored.
+		if len(msg.ID) > 0 {
+			s.write(&rpcMessage{ID: msg.ID, Error: &rpcError{Code: -32601, Message: "method not found: " + msg.Method}})
+		}
+	}
+}
+
+func mustJSON(v interface{}) json.RawMessage {
+	b, _ := json.Marshal(v)
+	return b
+}
+
+func validStationName(name string) bool {
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '_') {
+			return false
+		}
+	}
+	return name != ""
+}
+
+func (s *lspServer) publishDiagnostics(uri string) {
+	text := s.docs[uri]
+	lines := strings.Split(text, "\n")
+	data := s.analyze(uri)
+	diagnostics := []lspDiagnostic{}
+	for _, e := range data.Errors {
+		if e.File != "" && e.File != uriPath(uri) {
+			// Problems inside included files are reported when those are opened.
+			continue
+		}
+		line := 0
+		if e.Line > 0 {
+			line = e.Line - 1
+		}
+		end := 0
+		if line < len(lines) {
+			end = len(strings.TrimRight(lines[line], "\r"))
+		}
+		diagnostics = append(diagnostics, lspDiagnostic{
+			Range:    lspRange{Start: lspPosition{line, 0}, End: lspPosition{line, end}},
+			Severity: 1,
+			Source:   "stations",
+			Message:  e.Msg,
+		})
+	}
+	s.write(&rpcMessage{Method: "textDocument/publishDiagnostics", Params: mustJSON(map[string]interface{}{
+		"uri": uri, "diagnostics": diagnostics,
+	})})
+}
+
+// analyze parses an open document. Documents with a file: URI also pull in
+// their includes, preferring the unsaved text of other open documents.
+func (s *lspServer) analyze(uri string) *MapData {
+	text := s.docs[uri]
+	path := uriPath(uri)
+	if path == "" {
+		return parseMap(strings.NewReader(text), s.parse)
+	}
+	read := func(name string) ([]byte, error) {
+		for docURI, docText := range s.docs {
+			if uriPath(docURI) == name {
+				return []byte(docText), nil
+			}
+		}
+		return os.ReadFile(name)
+	}
+	data, err := loadMapFrom([]string{path}, s.parse, read)
+	if err != nil {
+		return parseMap(strings.NewReader(text), s.parse)
+	}
+	return data
+}
+
+func uriPath(uri string) string {
+	if !strings.HasPrefix(uri, "file://") {
+		return ""
+	}
+	path, err := url.PathUnescape(strings.TrimPrefix(uri, "file://"))
+	if err != nil {
+		return ""
+	}
+	return filepath.Clean(path)
+}
+
+// scanTokens finds station names in the raw document text, keeping the
+// columns that parseMap throws away when it strips spaces.
+func scanTokens(text string) []mapToken {
+	var tokens []mapToken
+	section := ""
+	for n, raw := range strings.Split(text, "\n") {
+		line := strings.TrimRight(raw, "\r")
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		trimmed := strings.ReplaceAll(line, " ", "")
+		if strings.HasPrefix(trimmed, "stations:") {
+			section = "stations"
+			continue
+		}
+		if strings.HasPrefix(trimmed, "connections:") {
+			section = "connections"
+			continue
+		}
+		if strings.HasPrefix(trimmed, "lines:") {
+			section = "lines"
+			continue
+		}
+		if strings.HasPrefix(trimmed, "include:") {
+			continue
+		}
+		switch section {
+		case "stations":
+			field := line
+			if i := strings.Index(field, ","); i >= 0 {
+				field = field[:i]
+			}
+			if tok, ok := fieldToken(field, n, 0); ok {
+				tok.Def = true
+				tokens = append(tokens, tok)
+			}
+		case "connections":
+			offset := 0
+			for _, field := range strings.Split(line, "-") {
+				if tok, ok := fieldToken(field, n, offset); ok {
+					tokens = append(tokens, tok)
+				}
+				offset += len(field) + 1
+			}
+		case "lines":
+			// name,colour,station,station...: only the stations are names.
+			offset := 0
+			for i, field := range strings.Split(line, ",") {
+				if i >= 2 {
+					if tok, ok := fieldToken(field, n, offset); ok {
+						tokens = append(tokens, tok)
+					}
+				}
+				offset += len(field) + 1
+			}
+		}
+	}
+	return tokens
+}
+
+func fieldToken(field string, line, offset int) (mapToken, bool) {
+	name := strings.TrimSpace(field)
+	if name == "" {
+		return mapToken{}, false
+	}
+	return mapToken{Name: name, Line: line, Col: offset + strings.Index(field, name)}, true
+}
+
+func tokenAt(tokens []mapToken, pos lspPosition) (mapToken, bool) {
+	for _, tok := range tokens {
+		if tok.Line == pos.Line && pos.Character >= tok.Col && pos.Character <= tok.Col+len(tok.Name) {
+			return tok, true
+		}
+	}
+	return mapToken{}, false
+}
+
+func tokenRange(tok mapToken) lspRange {
+	return lspRange{Start: lspPosition{tok.Line, tok.Col}, End: lspPosition{tok.Line, tok.Col + len(tok.Name)}}
+}
+
+func (s *lspServer) completion(p textDocumentPosition) []map[string]interface{} {
+	text := s.docs[p.TextDocument.URI]
+	items := []map[string]interface{}{}
+	switch sectionAt(text, p.Position.Line) {
+	case "connections":
+	case "lines":
+		// The name and colour of a line come before its stations.
+		lines := strings.Split(text, "\n")
+		if p.Position.Line >= len(lines) {
+			return items
+		}
+		line := lines[p.Position.Line]
+		if strings.Count(line[:min(max(p.Position.Character, 0), len(line))], ",") < 2 {
+			return items
+		}
+	default:
+		return items
+	}
+	data := s.analyze(p.TextDocument.URI)
+	names := make([]string, 0, len(data.Stations))
+	for name := range data.Stations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		st := data.Stations[name]
+		items = append(items, map[string]interface{}{
+			"label":  name,
+			"kind":   12, // Value
+			"detail": st.Coordinates(),
+		})
+	}
+	return items
+}
+
+func sectionAt(text string, line int) string {
+	section := ""
+	for n, raw := range strings.Split(text, "\n") {
+		if n >= line {
+			break
+		}
+		trimmed := strings.ReplaceAll(raw, " ", "")
+		if strings.HasPrefix(trimmed, "stations:") {
+			section = "stations"
+		} else if strings.HasPrefix(trimmed, "connections:") {
+			section = "connections"
+		} else if strings.HasPrefix(trimmed, "lines:") {
+			section = "lines"
+		}
+	}
+	return section
+}
+
+func (s *lspServer) definition(p textDocumentPosition) interface{} {
+	tokens := scanTokens(s.docs[p.TextDocument.URI])
+	tok, ok := tokenAt(tokens, p.Position)
+	if !ok {
+		return nil
+	}
+	for _, def := range tokens {
+		if def.Def && def.Name == tok.Name {
+			return lspLocation{URI: p.TextDocument.URI, Range: tokenRange(def)}
+		}
+	}
+	return nil
+}
+
+func (s *lspServer) hover(p textDocumentPosition) interface{} {
+	text := s.docs[p.TextDocument.URI]
+	tok, ok := tokenAt(scanTokens(text), p.Position)
+	if !ok {
+		return nil
+	}
+	data := s.analyze(p.TextDocument.URI)
+	st, exists := data.Stations[tok.Name]
+	if !exists {
+		return map[string]interface{}{
+			"contents": map[string]string{"kind": "markdown", "value": fmt.Sprintf("**%s** is not specified within stations section", tok.Name)},
+			"range":    tokenRange(tok),
+		}
+	}
+	return map[string]interface{}{
+		"contents": map[string]string{
+			"kind":  "markdown",
+			"value": fmt.Sprintf("**%s**\n\ncoordinates: (%s)\n\nconnections: %d", st.Name, strings.ReplaceAll(st.Coordinates(), ",", ", "), len(data.Connections[st.Name])),
+		},
+		"range": tokenRange(tok),
+	}
+}
+
+// rename renames the station under the cursor everywhere in the document. A
+// name that another station already has is refused, as the rename would
+// define that station twice.
+func (s *lspServer) rename(p textDocumentPosition, newName string) (interface{}, *rpcError) {
+	tokens := scanTokens(s.docs[p.TextDocument.URI])
+	tok, ok := tokenAt(tokens, p.Position)
+	if !ok {
+		return nil, nil
+	}
+	if newName != tok.Name && hasStation(s.analyze(p.TextDocument.URI).Stations, newName) {
+		return nil, &rpcError{Code: -32602, Message: fmt.Sprintf("Station %s already exists", newName)}
+	}
+	edits := []lspTextEdit{}
+	for _, other := range tokens {
+		if other.Name == tok.Name {
+			edits = append(edits, lspTextEdit{Range: tokenRange(other), NewText: newName})
+		}
+	}
+	return map[string]interface{}{
+		"changes": map[string][]lspTextEdit{p.TextDocument.URI: edits},
+	}, nil
+}
+
+func lspMain(cfg ParseConfig) {
+	if err := runLanguageServer(os.Stdin, os.Stdout, cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: language server: %s\n", err)
+		os.Exit(0)
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// lspClient drives runLanguageServer over in-memory pipes, framing messages
+// with Content-Length headers as an editor would.
+type lspClient struct {
+	t    *testing.T
+	in   *io.PipeWriter
+	out  *bufio.Reader
+	done chan error
+	id   int
+}
+
+func startLanguageServer(t *testing.T) *lspClient {
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	c := &lspClient{t: t, in: inW, out: bufio.NewReader(outR), done: make(chan error, 1)}
+	go func() {
+		err := runLanguageServer(inR, outW, ParseConfig{})
+		outW.Close()
+		c.done <- err
+	}()
+	return c
+}
+
+func (c *lspClient) send(msg map[string]interface{}) {
+	c.t.Helper()
+	msg["jsonrpc"] = "2.0"
+	body, _ := json.Marshal(msg)
+	if _, err := fmt.Fprintf(c.in, "Content-Length: %d\r\n\r\n%s", len(body), body); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *lspClient) notify(method string, params interface{}) {
+	c.t.Helper()
+	c.send(map[string]interface{}{"method": method, "params": params})
+}
+
+// receive reads one framed message from the server.
+func (c *lspClient) receive() map[string]interface{} {
+	c.t.Helper()
+	length := -1
+	for {
+		header, err := c.out.ReadString('\n')
+		if err != nil {
+			c.t.Fatalf("reading header: %s", err)
+		}
+		header = strings.TrimSpace(header)
+		if header == "" {
+			break
+		}
+		if value, ok := strings.CutPrefix(header, "Content-Length: "); ok {
+			length, _ = strconv.Atoi(value)
+		}
+	}
+	if length < 0 {
+		c.t.Fatal("message without Content-Length")
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(c.out, body); err != nil {
+		c.t.Fatal(err)
+	}
+	var msg map[string]interface{}
+	if err := json.Unmarshal(body, &msg); err != nil {
+		c.t.Fatalf("%s: %s", err, body)
+	}
+	return msg
+}
+
+// request sends a request and returns its response.
+func (c *lspClient) request(method string, params interface{}) map[string]interface{} {
+	c.t.Helper()
+	c.id++
+	c.send(map[string]interface{}{"id": c.id, "method": method, "params": params})
+	msg := c.receive()
+	if id, _ := msg["id"].(float64); int(id) != c.id {
+		c.t.Fatalf("%s: expected the response to request %d, got %v", method, c.id, msg)
+	}
+	return msg
+}
+
+func at(uri string, line, character int) map[string]interface{} {
+	return map[string]interface{}{
+		"textDocument": map[string]string{"uri": uri},
+		"position":     map[string]int{"line": line, "character": character},
+	}
+}
+
+const lspMap = `stations:
+waterloo,3,1
+victoria, 6,7
+euston,11,23
+connections:
+waterloo-victoria
+victoria - euston
+euston-nowhere
+lines:
+circle,red,waterloo, victoria
+`
+
+func TestLanguageServerRoundTrip(t *testing.T) {
+	const uri = "untitled:london"
+	c := startLanguageServer(t)
+
+	init := c.request("initialize", map[string]interface{}{})
+	caps, _ := init["result"].(map[string]interface{})["capabilities"].(map[string]interface{})
+	if caps["renameProvider"] != true || caps["definitionProvider"] != true {
+		t.Fatalf("missing capabilities in %v", init)
+	}
+
+	c.notify("textDocument/didOpen", map[string]interface{}{
+		"textDocument": map[string]string{"uri": uri, "text": lspMap},
+	})
+	diag := c.receive()
+	if diag["method"] != "textDocument/publishDiagnostics" {
+		t.Fatalf("expected diagnostics, got %v", diag)
+	}
+	list := diag["params"].(map[string]interface{})["diagnostics"].([]interface{})
+	if len(list) != 1 {
+		t.Fatalf("expected one diagnostic, got %v", list)
+	}
+	d := list[0].(map[string]interface{})
+	if line := d["range"].(map[string]interface{})["start"].(map[string]interface{})["line"]; line != 7.0 || !strings.Contains(d["message"].(string), "nowhere") {
+		t.Fatalf("diagnostic should be on line 7 about nowhere, got %v", d)
+	}
+
+	// victoria on "victoria - euston" is defined on line 2.
+	def := c.request("textDocument/definition", at(uri, 6, 2))["result"].(map[string]interface{})
+	if start := def["range"].(map[string]interface{})["start"].(map[string]interface{}); start["line"] != 2.0 || start["character"] != 0.0 {
+		t.Fatalf("definition of victoria at %v, want line 2", def)
+	}
+
+	// and so is victoria on the circle line, past its name and colour.
+	def = c.request("textDocument/definition", at(uri, 9, 22))["result"].(map[string]interface{})
+	if start := def["range"].(map[string]interface{})["start"].(map[string]interface{}); start["line"] != 2.0 {
+		t.Fatalf("definition of victoria on the circle line at %v, want line 2", def)
+	}
+	if def := c.request("textDocument/definition", at(uri, 9, 2))["result"]; def != nil {
+		t.Fatalf("the name of a line is not a station, got %v", def)
+	}
+
+	hover := c.request("textDocument/hover", at(uri, 6, 12))["result"].(map[string]interface{})
+	if value := hover["contents"].(map[string]interface{})["value"].(string); !strings.Contains(value, "**euston**") || !strings.Contains(value, "(11, 23)") {
+		t.Fatalf("hover on euston: %s", value)
+	}
+	hover = c.request("textDocument/hover", at(uri, 9, 12))["result"].(map[string]interface{})
+	if value := hover["contents"].(map[string]interface{})["value"].(string); !strings.Contains(value, "**waterloo**") {
+		t.Fatalf("hover on waterloo in the circle line: %s", value)
+	}
+
+	items := c.request("textDocument/completion", at(uri, 5, 0))["result"].([]interface{})
+	var labels []string
+	for _, item := range items {
+		labels = append(labels, item.(map[string]interface{})["label"].(string))
+	}
+	if strings.Join(labels, ",") != "euston,victoria,waterloo" {
+		t.Fatalf("completion offered %v", labels)
+	}
+	if items := c.request("textDocument/completion", at(uri, 9, 29))["result"].([]interface{}); len(items) != 3 {
+		t.Fatalf("completion among the stations of a line offered %v", items)
+	}
+	for _, char := range []int{0, 8} {
+		if items := c.request("textDocument/completion", at(uri, 9, char))["result"].([]interface{}); len(items) != 0 {
+			t.Fatalf("completion on the name or colour of a line offered %v", items)
+		}
+	}
+	if items := c.request("textDocument/completion", at(uri, 1, 0))["result"].([]interface{}); len(items) != 0 {
+		t.Fatalf("completion in the stations section offered %v", items)
+	}
+
+	rename := func(newName string) map[string]interface{} {
+		params := at(uri, 5, 0)
+		params["newName"] = newName
+		return c.request("textDocument/rename", params)
+	}
+	result := rename("waterloo_east")["result"].(map[string]interface{})
+	edits := result["changes"].(map[string]interface{})[uri].([]interface{})
+	if len(edits) != 3 {
+		t.Fatalf("renaming waterloo should edit its definition, connection and line, got %v", edits)
+	}
+	last := edits[2].(map[string]interface{})["range"].(map[string]interface{})["start"].(map[string]interface{})
+	if last["line"] != 9.0 || last["character"] != 11.0 {
+		t.Fatalf("renaming waterloo should edit the circle line at 9:11, got %v", edits)
+	}
+	for _, name := range []string{"victoria", "Bad-Name"} {
+		if reply := rename(name); reply["error"] == nil || reply["result"] != nil {
+			t.Fatalf("renaming waterloo to %s should fail, got %v", name, reply)
+		}
+	}
+
+	c.request("shutdown", nil)
+	c.notify("exit", nil)
+	if err := <-c.done; err != nil {
+		t.Fatalf("server stopped with %s", err)
+	}
+}
@@ -2,12 +2,16 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"flag"
 	"fmt"
+	"io"
 	"math"
 	"os"
-	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
 const (
@@ -27,50 +31,280 @@ type Station struct {
 	Name string
 	X    int
 	Y    int
+	// Stations of a map with "coordinates: geo" have a latitude and longitude
+	// in decimal degrees instead of X and Y. Coords keeps them as written in
+	// the map, so exports show the original values.
+	Geo    bool
+	Lat    float64
+	Lon    float64
+	Coords string
 }
 
-var pathFound bool
-var errorsfound bool
-var conflictExists bool
+// Coordinates returns the station's position as written in a map file.
+func (s Station) Coordinates() string {
+	if s.Geo {
+		return s.Coords
+	}
+	return fmt.Sprintf("%d,%d", s.X, s.Y)
+}
 
-func Mapreader(mapfile string, start string, end string) (map[string]Station, map[string][]string) {
-	if start == end {
-		fmt.Fprintf(os.Stderr, "Error: Start and end stations are same (%s)\n", start)
-		os.Exit(0)
+// Position places the station on a plane: X and Y, or longitude and latitude
+// for geo stations.
+func (s Station) Position() (float64, float64) {
+	if s.Geo {
+		return s.Lon, s.Lat
 	}
-	file, err := os.OpenFile(mapfile, os.O_RDONLY, 0644)
-	if err != nil {
-		fmt.Println("error reading the map:", err)
-		os.Exit(0)
+	return float64(s.X), float64(s.Y)
+}
+
+// MapError is a problem found while parsing a map file. Line is 1-based, or 0
+// when the problem concerns the file as a whole. File is only set when the
+// network was loaded from more than one file.
+type MapError struct {
+	File string
+	Line int
+	Msg  string
+	// missing is the station a connection refers to but the file does not
+	// define, which another file of the same network may.
+	missing string
+}
+
+func (e MapError) Error() string {
+	if e.File != "" && e.Line > 0 {
+		return fmt.Sprintf("Error: %s:%d: %s", e.File, e.Line, e.Msg)
+	}
+	if e.File != "" {
+		return fmt.Sprintf("Error: %s: %s", e.File, e.Msg)
+	}
+	return "Error: " + e.Msg
+}
+
+// MapInclude is an "include: other.txt" directive.
+type MapInclude struct {
+	Path string
+	Line int
+}
+
+// MapData holds everything parseMap learns from a map file.
+type MapData struct {
+	Stations       map[string]Station
+	Connections    map[string][]string
+	Defined        map[string]int // line on which each station was defined
+	Tracks         map[string]int // line on which each track was first defined, keyed by trackKey
+	Includes       []MapInclude
+	Errors         []MapError
+	HasStations    bool
+	HasConnections bool
+	TooLarge       bool
+	Geo            bool    // the map has a "coordinates: geo" header
+	Tolerance      float64 // metres within which two geo stations clash
+	HeaderLine     int     // line of the coordinates header, 0 when there is none
+	Lines          []MapLine
+}
+
+// defaultMaxStations is the most stations a map may have unless
+// --max-stations says otherwise.
+const defaultMaxStations = 10000
+
+// ParseConfig holds the limits a map is read with.
+type ParseConfig struct {
+	MaxStations int // most stations a map may have, defaultMaxStations when 0
+}
+
+func (cfg ParseConfig) maxStations() int {
+	if cfg.MaxStations > 0 {
+		return cfg.MaxStations
 	}
+	return defaultMaxStations
+}
 
-	pattern := `^[a-z_0-9]+$`
-	match := regexp.MustCompile(pattern)
-	stations := make(map[string]Station)
-	connections := make(map[string][]string)
-	scanner := bufio.NewScanner(file)
-	occCoords := make(map[string]bool)
+// stationLimit formats the most stations a map may have for messages.
+func (cfg ParseConfig) stationLimit() string {
+	digits := strconv.Itoa(cfg.maxStations())
+	for i := len(digits) - 3; i > 0; i -= 3 {
+		digits = digits[:i] + "," + digits[i:]
+	}
+	return digits
+}
+
+// parseMap reads a map without printing or exiting, so it can be shared by the
+// command line tool and the language server.
+func parseMap(r io.Reader, cfg ParseConfig) *MapData {
+	data := parseSource(r, cfg)
+	checkMap(data)
+	return data
+}
+
+// parsedTrack is a connection line, by the numbers of its stations.
+type parsedTrack struct {
+	a, b  int32
+	line  int
+	first bool // the first line connecting the two stations
+}
+
+// parseSource reads one map file and reports the errors of its lines. The
+// checks that need the whole network, of lines and of the sections being
+// there, are left to checkMap or, for a network of several files, to
+// mergeSources.
+//
+// The map is read line by line and never held in memory as a whole. Station
+// names are interned: a name gets a number the first time it is seen, and
+// stations and tracks are kept in slices by number while the file streams
+// past, with duplicate tracks found in a set keyed by the two numbers. The
+// maps of MapData are filled at the end, once their sizes are known, which
+// keeps networks of a million stations to a few seconds. The tables used
+// while reading are sized up front by sizeHint, so they are not grown and
+// rehashed over and over as the file streams past.
+func parseSource(r io.Reader, cfg ParseConfig) *MapData {
+	data := &MapData{}
+	hint := sizeHint(r, cfg)
+	ids := make(map[string]int32, hint)
+	names := make([]string, 0, hint)
+	stations := make([]Station, 0, hint)
+	defined := make([]int, 0, hint) // line each station was first defined on, 0 when it was not
+	count := 0                      // stations defined so far
+	intern := func(name string) int32 {
+		if id, ok := ids[name]; ok {
+			return id
+		}
+		id := int32(len(names))
+		ids[name] = id
+		names = append(names, name)
+		stations = append(stations, Station{})
+		defined = append(defined, 0)
+		return id
+	}
+	// A network usually has a track or two for every station.
+	tracks := make([]parsedTrack, 0, 2*hint)
+	seen := make(map[uint64]bool, 2*hint)
+	scanner := bufio.NewScanner(r)
+	var occCoords map[[2]int]bool    // made with the first grid station
+	occText := make(map[string]bool) // coordinates that are not whole numbers
+	geoSeen := newGeoGrid(0)
 	section := ""
-	hasConnections := false
-	hasStations := false
-	startExists := false
-	endExists := false
+	lineNo := 0
+	report := func(format string, args ...interface{}) {
+		data.Errors = append(data.Errors, MapError{Line: lineNo, Msg: fmt.Sprintf(format, args...)})
+	}
+	finish := func() {
+		data.Stations = make(map[string]Station, count)
+		data.Defined = make(map[string]int, count)
+		degree := make([]int, len(names))
+		for id, name := range names {
+			if defined[id] > 0 {
+				data.Stations[name] = stations[id]
+				data.Defined[name] = defined[id]
+			}
+		}
+		unique, connected := 0, 0
+		for _, t := range tracks {
+			for _, id := range [2]int32{t.a, t.b} {
+				if degree[id] == 0 {
+					connected++
+				}
+				degree[id]++
+			}
+			if t.first {
+				unique++
+			}
+		}
+		// Every station's connections are a window of one shared array,
+		// capped so that appending to one never runs into the next.
+		shared := make([]string, 2*len(tracks))
+		adjacent := make([][]string, len(names))
+		at := 0
+		for id, n := range degree {
+			adjacent[id] = shared[at : at : at+n]
+			at += n
+		}
+		// The keys of Tracks are cut from one string rather than each
+		// allocated on its own.
+		size := 0
+		for _, t := range tracks {
+			if t.first {
+				size += len(names[t.a]) + 1 + len(names[t.b])
+			}
+		}
+		var keys strings.Builder
+		keys.Grow(size)
+		for _, t := range tracks {
+			if t.first {
+				// as trackKey writes them, the smaller name first
+				a, b := names[t.a], names[t.b]
+				if a > b {
+					a, b = b, a
+				}
+				keys.WriteString(a)
+				keys.WriteByte('-')
+				keys.WriteString(b)
+			}
+		}
+		all := keys.String()
+		data.Connections = make(map[string][]string, connected)
+		data.Tracks = make(map[string]int, unique)
+		from := 0
+		for _, t := range tracks {
+			adjacent[t.a] = append(adjacent[t.a], names[t.b])
+			adjacent[t.b] = append(adjacent[t.b], names[t.a])
+			if t.first {
+				n := len(names[t.a]) + 1 + len(names[t.b])
+				data.Tracks[all[from:from+n]] = t.line
+				from += n
+			}
+		}
+		for id, n := range degree {
+			if n > 0 {
+				data.Connections[names[id]] = adjacent[id]
+			}
+		}
+	}
 
 	for scanner.Scan() {
+		lineNo++
 		line := scanner.Text()
 		line = strings.ReplaceAll(line, " ", "")
-		parts := strings.Split(line, "#")
-		if len(parts) > 0 {
-			line = parts[0]
-		}
+		line, _, _ = strings.Cut(line, "#")
 		if strings.HasPrefix(line, "stations:") {
 			section = "stations"
-			hasStations = true
+			data.HasStations = true
 			continue
 		}
 		if strings.HasPrefix(line, "connections:") {
 			section = "connections"
-			hasConnections = true
+			data.HasConnections = true
+			continue
+		}
+		if strings.HasPrefix(line, "lines:") {
+			section = "lines"
+			continue
+		}
+		if strings.HasPrefix(line, "include:") {
+			data.Includes = append(data.Includes, MapInclude{Path: strings.TrimPrefix(line, "include:"), Line: lineNo})
+			continue
+		}
+		if strings.HasPrefix(line, "coordinates:") {
+			switch mode := strings.TrimPrefix(line, "coordinates:"); {
+			case count > 0:
+				report("coordinates header has to come before the stations")
+			case mode == "geo":
+				data.Geo = true
+			case mode != "grid":
+				report("unknown coordinates %s, use grid or geo", mode)
+			}
+			data.HeaderLine = lineNo
+			continue
+		}
+		if strings.HasPrefix(line, "tolerance:") {
+			value := strings.TrimSuffix(strings.TrimPrefix(line, "tolerance:"), "m")
+			tolerance, err := strconv.ParseFloat(value, 64)
+			if err != nil || tolerance < 0 || math.IsInf(tolerance, 0) || math.IsNaN(tolerance) {
+				report("unable to convert tolerance (%s) to a non-negative number of metres", value)
+			} else if count > 0 {
+				report("tolerance has to come before the stations")
+			} else {
+				data.Tolerance = tolerance
+				geoSeen = newGeoGrid(tolerance)
+			}
 			continue
 		}
 		if line == "" {
@@ -78,87 +312,187 @@ func Mapreader(mapfile string, start string, end string) (map[string]Station, ma
 		}
 
 		if section == "stations" {
-			parts := strings.Split(line, ",")
-			if len(parts) == 3 {
-				station := parts[0]
-				if station == start {
-					startExists = true
-				}
-				if station == end {
-					endExists = true
-				}
-				if !match.MatchString(station) {
-					fmt.Fprintf(os.Stderr, "Error: Station (%s) should be composed by only lowercase, numbers and underscore characters\n", station)
-					errorsfound = true
-				}
-				if _, exists := stations[station]; exists {
-					fmt.Fprintf(os.Stderr, "Error: Station %s defined more than once\n", station)
-					errorsfound = true
+			station, rest, _ := strings.Cut(line, ",")
+			first, second, ok := strings.Cut(rest, ",")
+			if ok && !strings.Contains(second, ",") {
+				if !validStationName(station) {
+					report("Station (%s) should be composed by only lowercase, numbers and underscore characters", station)
 				}
-				if strings.Contains(parts[1], "-") || strings.Contains(parts[2], "-") {
-					fmt.Fprintf(os.Stderr, "Error: Station "+station+" contains negative coordinates\n")
-					errorsfound = true
-				}
-				x, _ := strconv.Atoi(parts[1])
-				y, _ := strconv.Atoi(parts[2])
-				stations[station] = Station{
-					Name: station,
-					X:    x,
-					Y:    y,
+				id := intern(station)
+				if defined[id] > 0 {
+					report("Station %s defined more than once", station)
+				} else {
+					defined[id] = lineNo
+					count++
 				}
-				if !occCoords[(parts[1] + " " + parts[2])] {
-					occCoords[(parts[1] + " " + parts[2])] = true
+				if data.Geo {
+					st, err := parseGeoStation(names[id], first, second)
+					if err != nil {
+						report("%s", err)
+					}
+					stations[id] = st
+					if other, clash := geoSeen.near(st); clash && err == nil {
+						report("Station %s at %s is within %g metres of station %s at %s", station, st.Coords, data.Tolerance, other.Name, other.Coords)
+					} else if err == nil {
+						geoSeen.add(st)
+					}
 				} else {
-					fmt.Fprintf(os.Stderr, "Error: Station %s tried to occupy coordinates %s,%s which are already occupied\n", station, parts[1], parts[2])
-					errorsfound = true
+					negative := strings.Contains(first, "-") || strings.Contains(second, "-")
+					if negative {
+						report("Station %s contains negative coordinates", station)
+					}
+					x, errX := strconv.Atoi(first)
+					y, errY := strconv.Atoi(second)
+					if (errX != nil || errY != nil) && !negative {
+						report("Station %s has coordinates %s,%s which are not whole numbers", station, first, second)
+					}
+					stations[id] = Station{
+						Name: names[id],
+						X:    x,
+						Y:    y,
+					}
+					// Compare the numbers, so that 01,2 clashes with 1,2.
+					taken := false
+					if errX != nil || errY != nil {
+						taken = occText[first+" "+second]
+						occText[first+" "+second] = true
+					} else {
+						if occCoords == nil {
+							occCoords = make(map[[2]int]bool, hint)
+						}
+						// The coordinates are taken when adding them leaves
+						// the set as large as it was.
+						n := len(occCoords)
+						occCoords[[2]int{x, y}] = true
+						taken = len(occCoords) == n
+					}
+					if taken {
+						report("Station %s tried to occupy coordinates %s,%s which are already occupied", station, first, second)
+					}
 				}
-				if len(stations) > 10000 {
-					fmt.Fprintf(os.Stderr, "Error: Train map exceeded the maximum number(10,000) of allowed stations, exiting...\n")
-					errorsfound = true
-					os.Exit(0)
+				if count > cfg.maxStations() {
+					report("Train map exceeded the maximum number(%s) of allowed stations, exiting...", cfg.stationLimit())
+					data.TooLarge = true
+					finish()
+					return data
 				}
 			} else {
-				fmt.Fprintf(os.Stderr, "Error: Insufficient variables for station in %s\n", parts)
-				errorsfound = true
+				report("Insufficient variables for station in %s", strings.Split(line, ","))
+			}
+		} else if section == "lines" {
+			if l, ok := parseLine(line, lineNo, report); ok {
+				data.Lines = append(data.Lines, l)
 			}
 		} else if section == "connections" {
-			parts := strings.Split(line, "-")
-			if len(parts) == 2 {
-				station1 := parts[0]
-				if _, exists := stations[station1]; !exists {
-					fmt.Fprintf(os.Stderr, "Error: Tried to make connection to %s, which is not specified within stations section\n", station1)
-					errorsfound = true
-				}
-				station2 := parts[1]
-				if _, exists := stations[station2]; !exists {
-					fmt.Fprintf(os.Stderr, "Error: Tried to make connection to %s, which is not specified within stations section\n", station2)
-					errorsfound = true
+			station1, station2, ok := strings.Cut(line, "-")
+			if ok && !strings.Contains(station2, "-") {
+				a, b := intern(station1), intern(station2)
+				for _, id := range [2]int32{a, b} {
+					if defined[id] == 0 {
+						data.Errors = append(data.Errors, MapError{Line: lineNo, missing: names[id],
+							Msg: fmt.Sprintf("Tried to make connection to %s, which is not specified within stations section", names[id])})
+					}
 				}
-				if contains(connections[station1], station2) || contains(connections[station2], station1) {
-					fmt.Fprintf(os.Stderr, "Error: duplicate line between "+station1+" and "+station2+"\n")
-					errorsfound = true
+				key := uint64(min(a, b))<<32 | uint64(max(a, b))
+				n := len(seen)
+				seen[key] = true
+				duplicate := len(seen) == n
+				if duplicate {
+					report("duplicate line between %s and %s", station1, station2)
 				}
-				connections[station1] = append(connections[station1], station2)
-				connections[station2] = append(connections[station2], station1)
+				tracks = append(tracks, parsedTrack{a: a, b: b, line: lineNo, first: !duplicate})
 			}
 		}
 	}
-	if !hasConnections {
-		fmt.Fprintf(os.Stderr, "Error: Train map does not contain connections\n")
+	finish()
+	return data
+}
+
+// sizeHint is the number of stations to size the tables of parseSource for:
+// the most a map may have or, when the size of r is known, as many as the
+// input has room for at one station to about 8 bytes, whichever is fewer.
+func sizeHint(r io.Reader, cfg ParseConfig) int {
+	hint := int64(cfg.maxStations())
+	switch r := r.(type) {
+	case interface{ Len() int }:
+		hint = min(hint, int64(r.Len())/8)
+	case *os.File:
+		if info, err := r.Stat(); err == nil && info.Mode().IsRegular() {
+			hint = min(hint, info.Size()/8)
+		}
 	}
-	if !hasStations {
-		fmt.Fprintf(os.Stderr, "Error: Train map does not contain stations\n")
+	return int(hint)
+}
+
+// checkMap finishes the checks of a map that is a network on its own: its
+// lines run over its own tracks and it has both sections.
+func checkMap(data *MapData) {
+	if data.TooLarge {
+		return
+	}
+	checkLines(data.Lines, data.Stations, data.Connections, func(line int, format string, args ...interface{}) {
+		data.Errors = append(data.Errors, MapError{Line: line, Msg: fmt.Sprintf(format, args...)})
+	})
+	if !data.HasConnections {
+		data.Errors = append(data.Errors, MapError{Msg: "Train map does not contain connections"})
+	}
+	if !data.HasStations {
+		data.Errors = append(data.Errors, MapError{Msg: "Train map does not contain stations"})
+	}
+}
+
+// Mapreader loads the map files and prints every error found in them. It exits
+// when the map cannot be used at all; otherwise valid reports whether the map
+// was free of errors.
+func Mapreader(mapfiles []string, start string, end string, cfg ParseConfig) (stations map[string]Station, connections map[string][]string, lines []MapLine, valid bool) {
+	stations, connections, lines, valid, ok := readNetwork(mapfiles, start, end, cfg)
+	if !ok {
+		os.Exit(0)
 	}
+	return stations, connections, lines, valid
+}
+
+// readNetwork is Mapreader for callers that have something left to do before
+// the program ends, such as writing the --explain trace: it returns ok false
+// where Mapreader exits.
+func readNetwork(mapfiles []string, start string, end string, cfg ParseConfig) (stations map[string]Station, connections map[string][]string, lines []MapLine, valid, ok bool) {
+	if start == end {
+		fmt.Fprintf(os.Stderr, "Error: Start and end stations are same (%s)\n", start)
+		return nil, nil, nil, false, false
+	}
+	data, err := loadMap(mapfiles, cfg)
+	if err != nil {
+		fmt.Println("error reading the map:", err)
+		return nil, nil, nil, false, false
+	}
+
+	valid = true
+	for _, e := range data.Errors {
+		fmt.Fprintln(os.Stderr, e)
+		if e.Line > 0 {
+			valid = false
+		}
+	}
+	if data.TooLarge {
+		return nil, nil, nil, false, false
+	}
+	_, startExists := data.Stations[start]
+	_, endExists := data.Stations[end]
 	if !startExists {
 		fmt.Fprintf(os.Stderr, "Error: Start station (%s) was not found within the train map\n", start)
 	}
 	if !endExists {
 		fmt.Fprintf(os.Stderr, "Error: End station (%s) was not found within the train map\n", end)
 	}
-	if !hasConnections || !hasStations || !startExists || !endExists {
-		os.Exit(0)
+	if !data.HasConnections || !data.HasStations || !startExists || !endExists {
+		return nil, nil, nil, false, false
 	}
-	return stations, connections
+	return data.Stations, data.Connections, data.Lines, valid, true
+}
+
+func hasStation(stations map[string]Station, name string) bool {
+	_, ok := stations[name]
+	return ok
 }
 
 func contains(station []string, connection string) bool {
@@ -171,11 +505,19 @@ func contains(station []string, connection string) bool {
 	return false
 }
 
+// distance is the straight line distance between two stations, in metres for
+// geo stations.
 func distance(s1, s2 Station) int {
+	if s1.Geo && s2.Geo {
+		return int(haversine(s1.Lat, s1.Lon, s2.Lat, s2.Lon))
+	}
 	return int(math.Sqrt(float64((s1.X-s2.X)*(s1.X-s2.X) + (s1.Y-s2.Y)*(s1.Y-s2.Y))))
 }
 
-func Dijkstra(stations map[string]Station, connections map[string][]string, start, end string, conflicts []string) []string {
+// Dijkstra returns the shortest path from start to end, or an empty path when
+// there is none. Stations and tracks leading only to conflicting stations are
+// removed from the maps on the way.
+func Dijkstra(stations map[string]Station, connections map[string][]string, start, end string, conflicts []string, trace *Explainer) []string {
 
 	dist := make(map[string]int)
 	prev := make(map[string]string)
@@ -192,26 +534,16 @@ func Dijkstra(stations map[string]Station, connections map[string][]string, star
 		var currentStation string
 		smallestDistance := int(^uint(0) >> 1)
 		for station := range unvisited {
-
-			if dist[station] < smallestDistance {
+			// Ties go to the first name, so the path does not depend on the
+			// order Go visits the map in.
+			if dist[station] < smallestDistance || dist[station] == smallestDistance && dist[station] != int(^uint(0)>>1) && station < currentStation {
 				smallestDistance = dist[station]
 				currentStation = station
 			}
 		}
 
 		if len(connections[currentStation]) == 0 {
-
-			if pathFound {
-
-				return []string{}
-			} else {
-				fmt.Fprintf(os.Stderr, "Error: no valid path between %s and %s\n", start, end)
-				os.Exit(0)
-			}
-		}
-		if currentStation == "" && !pathFound {
-			fmt.Fprintf(os.Stderr, "Error: no valid path between %s and %s\n", start, end)
-			errorsfound = true
+			return []string{}
 		}
 		if currentStation == end {
 			break
@@ -231,11 +563,15 @@ func Dijkstra(stations map[string]Station, connections map[string][]string, star
 						prev[neighbor] = currentStation
 						continue
 					} else {
+						trace.note("drop", map[string]interface{}{"station": currentStation, "conflict": neighbor},
+							"%s removed from the network, it only leads to conflicting station %s", currentStation, neighbor)
 						delete(stations, currentStation)
 					}
 				}
 				if len(connections[currentStation]) > 1 {
 
+					trace.note("sever", map[string]interface{}{"from": currentStation, "to": neighbor, "conflict": neighbor},
+						"track %s-%s removed, %s is already used by another route and %s has other tracks", currentStation, neighbor, neighbor, currentStation)
 					connections[neighbor] = sever(connections[neighbor], currentStation)
 					connections[currentStation] = sever(connections[currentStation], neighbor)
 
@@ -243,10 +579,14 @@ func Dijkstra(stations map[string]Station, connections map[string][]string, star
 				}
 				if len(connections[neighbor]) > 2 {
 
+					trace.note("sever", map[string]interface{}{"from": currentStation, "to": neighbor, "conflict": neighbor},
+						"track %s-%s removed, %s is already used by another route and has other tracks", currentStation, neighbor, neighbor)
 					connections[neighbor] = sever(connections[neighbor], currentStation)
 					connections[currentStation] = sever(connections[currentStation], neighbor)
 
 					if len(connections[currentStation]) == 0 {
+						trace.note("drop", map[string]interface{}{"station": currentStation},
+							"%s removed from the network, it has no tracks left", currentStation)
 						delete(stations, currentStation)
 					}
 					continue
@@ -269,10 +609,12 @@ func Dijkstra(stations map[string]Station, connections map[string][]string, star
 	}
 
 	path := []string{}
-	for u := end; u != ""; u = prev[u] {
+	for u := end; ; u = prev[u] {
+		if u == "" {
+			return []string{}
+		}
 		path = append([]string{u}, path...)
 		if u == start {
-			pathFound = true
 			break
 		}
 	}
@@ -313,52 +655,362 @@ func sever(connection []string, cut string) []string {
 	return result
 }
 
+// runUsage lists the options of a normal run and the other commands. Every
+// one of them is described in README.md.
+const runUsage = `  Options:
+    --explain[=json]               trace planner decisions
+    --map <file>                   merge another map file into the network, may be repeated
+    --max-stations <n>             most stations a map may have (10000)
+    --timeout <duration>           time budget for the route search, such as 5s
+    --depot <station>:<trains>     park trains at depots instead of one start, may be repeated
+    --depart <train>:<turn>        turn before which a train may not leave, may be repeated
+    --arrive-by <train>:<turn>     turn by which a train has to arrive, may be repeated
+    --scenario <file>              file with departure windows of the trains
+    --priority <train>:<priority>  plan trains with higher priorities first, may be repeated
+    --length <train>:<blocks>      long trains take up the stations and tracks behind them
+    --line <train>:<line>          run a train only on the tracks of a line, may be repeated
+    --signalling station|block     book stations, or every track as well (--signalling=block)
+    --cycles <n>                   round trips back to the start station
+    --continuous [--timeline]      run in continuous time, with --headway <t> and --dwell <t>
+    --stats[=json]                 print station and track utilisation
+    --heatmap <file>               write a utilisation heatmap as SVG
+    --memory                       report the time and memory loading the map took
+    --watch                        run again whenever a map file changes
+  Commands:
+    go run . diff <old map> <new map> [<start station> <end station> [<numeric amount of trains>]]
+    go run . robustness [--runs <runs>] [--seed <seed>] [--delay <distribution>] [--target <turns>] <map> <start> <end> <trains>
+    go run . advise [--within <distance>] [--top <changes>] <map> <start> <end> <trains>
+    go run . journey [--objective transfers|stops|distance] <map> <from station> <to station>
+    go run . compare [--timeout <duration>] <directory of map files> <numeric amount of trains>
+    go run . nearest|box|radius|route <map> <coordinates>...
+    go run . generate <numeric amount of stations> <path to the map file to write>
+    go run . repl <map>
+    go run . lsp
+  See README.md for every option and command.`
+
 func main() {
-	if len(os.Args) != 5 {
-		fmt.Fprintf(os.Stderr, "Error: incorrect number of arguments (%d), should be 5\n", len(os.Args))
+	args, opts, err := parseCommandLine(os.Args[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		os.Exit(0)
+	}
+	if len(args) == 2 && args[1] == "lsp" {
+		lspMain(opts.parse)
+		return
+	}
+	if len(args) == 3 && args[1] == "repl" {
+		replMain(args[2], opts.parse)
+		return
+	}
+	if len(args) >= 2 && args[1] == "diff" {
+		diffMain(args[2:], opts.parse)
+		return
+	}
+	if len(args) >= 2 && args[1] == "robustness" {
+		robustnessMain(args[2:], opts)
+		return
+	}
+	if len(args) >= 2 && args[1] == "journey" {
+		journeyMain(args[2:], opts)
+		return
+	}
+	if len(args) >= 2 && args[1] == "compare" {
+		compareMain(args[2:], opts)
+		return
+	}
+	if len(args) >= 2 && args[1] == "advise" {
+		adviseMain(args[2:], opts)
+		return
+	}
+	if len(args) >= 2 && args[1] == "generate" {
+		generateMain(args[2:])
+		return
+	}
+	if len(args) >= 2 && spatialArgs[args[1]] > 0 {
+		spatialMain(args[1], args[2:], opts.parse)
+		return
+	}
+	if len(opts.depots) > 0 && len(args) != 3 {
+		fmt.Fprintf(os.Stderr, "Error: incorrect number of arguments (%d), should be 3 when depots are given\n", len(args))
+		fmt.Println(Green, " To run the tool with depots:")
+		fmt.Println("  go run . --depot <station>:<trains> [--depot <station>:<trains>]... <path to file containing network map> <end station>", Reset)
+		os.Exit(0)
+	}
+	if len(opts.depots) == 0 && len(args) != 5 {
+		fmt.Fprintf(os.Stderr, "Error: incorrect number of arguments (%d), should be 5\n", len(args))
 		fmt.Println(Green, " To run the tool:")
-		fmt.Println("  go run . <path to file containing network map> <start station> <end station> <numeric amount of trains>", Reset)
+		fmt.Println("  go run . [options] <path to file containing network map> <start station> <end station> <numeric amount of trains>")
+		fmt.Println(runUsage, Reset)
 		os.Exit(0)
 	}
+	if opts.watch {
+		if len(opts.depots) > 0 {
+			fmt.Fprintf(os.Stderr, "Error: --watch is not supported together with depots\n")
+			os.Exit(0)
+		}
+		watchMain(args, opts)
+		return
+	}
+	run(args, opts, os.Stderr)
+}
+
+// run moves the trains of a normal or depot run and then writes the --explain
+// trace to explainOut. The run returns instead of exiting on errors, so the
+// trace is written for the failures it is there to explain too.
+func run(args []string, opts *options, explainOut io.Writer) {
+	var trace *Explainer
+	if opts.explain != "" {
+		trace = &Explainer{}
+	}
+	trainsMain(args, opts, trace)
+	writeExplain(explainOut, opts, trace)
+}
 
-	start := os.Args[2]
-	end := os.Args[3]
-	if strings.HasPrefix(os.Args[4], "-") {
-		fmt.Fprintf(os.Stderr, "Error: train value(%s) negative\n", os.Args[4])
-		errorsfound = true
+// trainsMain plans and moves the trains of a normal or depot run.
+func trainsMain(args []string, opts *options, trace *Explainer) {
+	if len(opts.depots) > 0 && opts.continuous {
+		fmt.Fprintf(os.Stderr, "Error: continuous time is not supported together with depots\n")
+		return
+	}
+	if len(opts.depots) > 0 && opts.cycles > 0 {
+		fmt.Fprintf(os.Stderr, "Error: round trips are not supported together with depots\n")
+		return
+	}
+	if len(opts.depots) > 0 && len(opts.lines) > 0 {
+		// Lines run between one start and the end, depots have a start each.
+		fmt.Fprintf(os.Stderr, "Error: trains on lines are not supported together with depots\n")
+		return
+	}
+	if len(opts.depots) > 0 {
+		depotMain(args, opts, trace)
+		return
 	}
-	traincount, err := strconv.Atoi(os.Args[4])
+
+	start := args[2]
+	end := args[3]
+	negative := strings.HasPrefix(args[4], "-")
+	if negative {
+		fmt.Fprintf(os.Stderr, "Error: train value(%s) negative\n", args[4])
+	}
+	traincount, err := strconv.Atoi(args[4])
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: unable to convert train numbers(%s) to integers\n", os.Args[4])
-		os.Exit(0)
+		fmt.Fprintf(os.Stderr, "Error: unable to convert train numbers(%s) to integers\n", args[4])
+		return
 	}
 
-	//Mapreader reads the map, checks most error scenarios and returns two mapy, on contains stations and coordinates
-	//and other stations and their connections.
-	stations, connections := Mapreader(os.Args[1], start, end)
-	startcon := connections[start]
-	var conflicts []string
-	paths, conflicts := pathPlanner(connections, stations, start, end, nil)
+	windows, err := loadWindows(opts)
+	if err == nil && len(windows) > 0 && traincount > 0 {
+		err = applyWindows(startTrains(traincount, start), windows)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		return
+	}
 
-	count := 1
-	for conflictExists {
-		count++
-		connections[start] = startcon
-		paths, conflicts = pathPlanner(connections, stations, start, end, conflicts)
-		if len(conflicts) == 0 {
-			conflictExists = false
-		}
+	//readNetwork reads the map, checks most error scenarios and returns two mapy, on contains stations and coordinates
+	//and other stations and their connections.
+	loading := time.Now()
+	stations, connections, lines, valid, ok := readNetwork(append([]string{args[1]}, opts.maps...), start, end, opts.parse)
+	if !ok {
+		return
+	}
+	if opts.memory {
+		writeMemory(os.Stderr, stations, connections, time.Since(loading))
+	}
+	ctx := context.Background()
+	if opts.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.timeout)
+		defer cancel()
+	}
+	paths, complete, err := planRoutes(ctx, stations, connections, start, end, traincount, trace)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		return
+	}
+	if !complete {
+		fmt.Fprintf(os.Stderr, "Note: the route search ran out of time after %s, the schedule may not be optimal\n", opts.timeout)
 	}
 
 	//Trainnames simply creates a map which is used to separate trains from others and hold current location
 	trains := Trainnames(traincount, start)
 
-	if !errorsfound {
-		Pathbuilder(trains, paths, stations, start)
-	} else {
+	if !valid || negative {
 		fmt.Println(Red, "Please fix listed errors", Reset)
+	} else if needsScheduler(opts, windows) {
+		windowed, routes, cfg, err := scheduledTrains(opts, windows, paths, lines, traincount, start, end)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			return
+		}
+		var turns [][]Move
+		if opts.continuous {
+			timings, err := loadTimings(opts, stations, connections)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+				return
+			}
+			turns = runContinuous(windowed, routes, cfg, timings, lines, opts.timeline)
+		} else {
+			turns = runTrains(windowed, routes, cfg, lines)
+		}
+		writeStats(opts, stations, connections, trainOrigins(windowed), turns)
+	} else {
+		turns := simulate(trains, paths, stations, start, trace)
+		printTurns(turns, newTurnPainter(lines, sameOrigin(traincount, start), nil))
+		writeStats(opts, stations, connections, sameOrigin(traincount, start), turns)
+	}
+}
+
+// writeExplain prints the --explain trace collected during the run.
+func writeExplain(w io.Writer, opts *options, trace *Explainer) {
+	if opts.explain == "json" {
+		trace.WriteJSON(w)
+	} else if opts.explain == "text" {
+		trace.WriteText(w)
 	}
+}
 
+type options struct {
+	explain   explainMode
+	maps      stringList
+	depots    stringList
+	depart    stringList
+	arriveBy  stringList
+	priority  stringList
+	length    stringList
+	scenario  string
+	schedule  ScheduleConfig
+	cycles    int
+	timeout   time.Duration
+	stats     statsMode
+	heatmap   string
+	watch     bool
+	runs      int
+	seed      int64
+	delay     delayModel
+	target    int
+	lines     stringList
+	objective string
+	within    float64
+	top       int
+	// continuous runs the trains in continuous time with the timings of
+	// the scenario, headway and dwell; timeline prints it by arrival time
+	// instead of by turn.
+	continuous bool
+	timeline   bool
+	headway    float64
+	dwell      float64
+	// parse holds --max-stations, for every map the run reads; memory is
+	// --memory.
+	parse  ParseConfig
+	memory bool
+}
+
+// stringList collects a flag that may be given several times.
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// parseCommandLine separates --flags from positional arguments, which may be
+// given in any order. The returned arguments start with the program name so
+// they can be indexed like os.Args. A lone "-4" is kept as a positional
+// argument so the negative train count check can report it.
+func parseCommandLine(argv []string) ([]string, *options, error) {
+	opts := &options{delay: defaultDelay}
+	fs := flag.NewFlagSet("stations", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.Var(&opts.explain, "explain", "trace planner decisions (text or json)")
+	fs.Var(&opts.maps, "map", "additional map file merged into the network")
+	fs.Var(&opts.depots, "depot", "station:trains parked at a depot, may be repeated")
+	fs.Var(&opts.depart, "depart", "train:turn before which a train may not leave, may be repeated")
+	fs.Var(&opts.arriveBy, "arrive-by", "train:turn by which a train has to arrive, may be repeated")
+	fs.Var(&opts.priority, "priority", "train:priority, higher priorities are planned first, may be repeated")
+	fs.Var(&opts.length, "length", "train:blocks, a long train takes up the stations and tracks behind it, may be repeated")
+	fs.Var(&opts.lines, "line", "train:line, the train only runs on the tracks of the line, may be repeated")
+	fs.StringVar(&opts.objective, "objective", "transfers", "what journey keeps lowest: transfers, stops or distance")
+	fs.StringVar(&opts.scenario, "scenario", "", "file with departure windows of the trains")
+	signalling := fs.String("signalling", "station", "station or block")
+	fs.IntVar(&opts.cycles, "cycles", 0, "number of round trips back to the start station")
+	fs.Var(&opts.stats, "stats", "print station and track utilisation (table or json)")
+	fs.BoolVar(&opts.watch, "watch", false, "re-run whenever the map file changes")
+	fs.StringVar(&opts.heatmap, "heatmap", "", "write a utilisation heatmap as SVG to this file")
+	fs.DurationVar(&opts.timeout, "timeout", 0, "time budget for the route search, such as 5s")
+	fs.IntVar(&opts.runs, "runs", 1000, "number of delayed runs for robustness")
+	fs.Int64Var(&opts.seed, "seed", 1, "seed of the first delayed run for robustness")
+	fs.Var(&opts.delay, "delay", "delay distribution for robustness, such as geometric:0.1")
+	fs.IntVar(&opts.target, "target", 0, "turns robustness checks the runs against, the planned turns when 0")
+	fs.Float64Var(&opts.within, "within", 0, "longest new track advise tries, the longest existing track when 0")
+	fs.IntVar(&opts.top, "top", 10, "number of changes advise lists")
+	fs.IntVar(&opts.parse.MaxStations, "max-stations", defaultMaxStations, "most stations a map may have")
+	fs.BoolVar(&opts.memory, "memory", false, "report the time and memory loading the map took")
+	fs.BoolVar(&opts.continuous, "continuous", false, "run the trains in continuous time with track times, headways and dwell times")
+	fs.BoolVar(&opts.timeline, "timeline", false, "print the time trains arrive instead of turns in continuous time")
+	fs.Float64Var(&opts.headway, "headway", 0, "least time between two trains entering a track in continuous time")
+	fs.Float64Var(&opts.dwell, "dwell", 0, "time trains stop at every station on their way in continuous time")
+
+	args := []string{os.Args[0]}
+	for i := 0; i < len(argv); i++ {
+		arg := argv[i]
+		if len(arg) < 2 || arg[0] != '-' || (arg[1] >= '0' && arg[1] <= '9') {
+			args = append(args, arg)
+			continue
+		}
+		chunk := []string{arg}
+		name, _, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if f := fs.Lookup(name); f != nil && !hasValue && !isBoolFlag(f) && i+1 < len(argv) {
+			chunk = append(chunk, argv[i+1])
+			i++
+		}
+		if err := fs.Parse(chunk); err != nil {
+			return nil, nil, err
+		}
+	}
+	if opts.timeout < 0 {
+		return nil, nil, fmt.Errorf("timeout (%s) should not be negative", opts.timeout)
+	}
+	if opts.runs < 1 {
+		return nil, nil, fmt.Errorf("unable to convert runs(%d) to a positive integer", opts.runs)
+	}
+	if opts.target < 0 {
+		return nil, nil, fmt.Errorf("target (%d) should not be negative", opts.target)
+	}
+	if _, ok := journeyObjectives[opts.objective]; !ok {
+		return nil, nil, fmt.Errorf("unknown objective %s, use transfers, stops or distance", opts.objective)
+	}
+	if opts.within < 0 {
+		return nil, nil, fmt.Errorf("distance (%g) should not be negative", opts.within)
+	}
+	if opts.headway < 0 || opts.dwell < 0 {
+		return nil, nil, fmt.Errorf("headway (%g) and dwell (%g) should not be negative", opts.headway, opts.dwell)
+	}
+	if opts.parse.MaxStations < 1 {
+		return nil, nil, fmt.Errorf("unable to convert max-stations(%d) to a positive integer", opts.parse.MaxStations)
+	}
+	if opts.top < 1 {
+		return nil, nil, fmt.Errorf("unable to convert top(%d) to a positive integer", opts.top)
+	}
+	if opts.cycles < 0 {
+		return nil, nil, fmt.Errorf("unable to convert cycles(%d) to a positive integer", opts.cycles)
+	}
+	switch *signalling {
+	case "station":
+	case "block":
+		opts.schedule.Block = true
+	default:
+		return nil, nil, fmt.Errorf("unknown signalling mode %s, use station or block", *signalling)
+	}
+	return args, opts, nil
+}
+
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
 }
 
 func newLocation(location string) *string {
@@ -366,18 +1018,102 @@ func newLocation(location string) *string {
 	return &loc
 }
 
-func pathPlanner(connections map[string][]string, stations map[string]Station, start, end string, conflicts []string) ([][]string, []string) {
+// replanRoutes runs pathPlanner until the route set is free of conflicts. It
+// works on copies of the network, so the caller's maps stay untouched. After
+// every round the conflict-free part of the routes is offered, so a result is
+// at hand when ctx ends the search early. It also stops when a set of
+// conflicts comes back, as replanning would then go round in circles.
+func replanRoutes(ctx context.Context, stations map[string]Station, connections map[string][]string, start, end string, offer func([][]string), trace *Explainer) {
+	stations, connections = copyNetwork(stations, connections)
+
+	startcon := connections[start]
+	round := 1
+	trace.begin("round", map[string]interface{}{"round": round}, "planning round %d", round)
+	paths, conflicts := pathPlanner(connections, stations, start, end, nil, trace)
+	trace.end()
+	seen := make(map[string]bool)
+	for len(conflicts) > 0 {
+		offer(conflictFree(paths))
+		sorted := append([]string(nil), conflicts...)
+		sort.Strings(sorted)
+		key := strings.Join(sorted, ",")
+		if seen[key] {
+			trace.note("cycle", map[string]interface{}{"conflicts": sorted},
+				"conflicts %s came back, replanning stopped", key)
+			return
+		}
+		seen[key] = true
+		if ctx.Err() != nil {
+			trace.note("cancelled", nil, "replanning stopped after %d round(s)", round)
+			return
+		}
+		round++
+		connections[start] = startcon
+		trace.begin("round", map[string]interface{}{"round": round, "conflicts": conflicts},
+			"replanning round %d avoiding %s", round, strings.Join(conflicts, ", "))
+		paths, conflicts = pathPlanner(connections, stations, start, end, conflicts, trace)
+		trace.end()
+	}
+	trace.note("routes", map[string]interface{}{"routes": forwardAll(paths)}, "%d route(s) chosen after %d round(s)", len(paths), round)
+	offer(paths)
+}
+
+// reachable reports whether end can be reached from start using only stations
+// that are defined in the map.
+func reachable(stations map[string]Station, connections map[string][]string, start, end string) bool {
+	if _, ok := stations[start]; !ok {
+		return false
+	}
+	seen := map[string]bool{start: true}
+	queue := []string{start}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		if current == end {
+			return true
+		}
+		for _, neighbor := range connections[current] {
+			if _, ok := stations[neighbor]; ok && !seen[neighbor] {
+				seen[neighbor] = true
+				queue = append(queue, neighbor)
+			}
+		}
+	}
+	return false
+}
+
+func copyNetwork(stations map[string]Station, connections map[string][]string) (map[string]Station, map[string][]string) {
+	stations2 := make(map[string]Station, len(stations))
+	for name, station := range stations {
+		stations2[name] = station
+	}
+	connections2 := make(map[string][]string, len(connections))
+	for name, neighbors := range connections {
+		connections2[name] = append([]string(nil), neighbors...)
+	}
+	return stations2, connections2
+}
+
+func pathPlanner(connections map[string][]string, stations map[string]Station, start, end string, conflicts []string, trace *Explainer) ([][]string, []string) {
 	//Dijkstra calculates distances between stations and returns viable paths from start to end
 	var paths [][]string
 	connections2 := connections
 	for len(stations) > 0 {
 
-		path := Dijkstra(stations, connections2, start, end, conflicts)
+		trace.begin("dijkstra", map[string]interface{}{"start": start, "end": end, "avoid": conflicts},
+			"Dijkstra from %s to %s", start, end)
+		path := Dijkstra(stations, connections2, start, end, conflicts, trace)
 
 		if len(path) == 0 {
+			trace.note("path", nil, "no further path")
+			trace.end()
 			break
 		}
+		trace.note("path", map[string]interface{}{"path": forward(path)}, "found %s", strings.Join(forward(path), " -> "))
+		trace.end()
 
+		trace.note("sever", map[string]interface{}{"from": start, "to": path[len(path)-2]},
+			"track %s-%s removed so the next search leaves %s another way", start, path[len(path)-2], start)
 		connections2[start] = sever(connections2[start], path[len(path)-2])
 		connections2[path[len(path)-2]] = sever(connections2[path[len(path)-2]], start)
 
@@ -385,7 +1121,8 @@ func pathPlanner(connections map[string][]string, stations map[string]Station, s
 
 		conflicts = findConflicts(paths, start, end)
 		if len(conflicts) > 0 {
-			conflictExists = true
+			trace.note("conflicts", map[string]interface{}{"stations": conflicts},
+				"stations shared by more than one route: %s", strings.Join(conflicts, ", "))
 		}
 
 	}
@@ -424,11 +1161,36 @@ func findConflicts(slice [][]string, start, end string) []string {
 			conflicts = append(conflicts, str)
 		}
 	}
+	sort.Strings(conflicts)
 
 	return conflicts
 }
 
-func Pathbuilder(trains map[string]*Traininfo, paths [][]string, stations map[string]Station, start string) {
+// Move is one train arriving at a station during a turn.
+type Move struct {
+	Train   string `json:"train"`
+	Station string `json:"station"`
+}
+
+// Pathbuilder moves the trains along the paths, prints every turn and returns
+// the turns it printed.
+func Pathbuilder(trains map[string]*Traininfo, paths [][]string, stations map[string]Station, start string, trace *Explainer) [][]Move {
+	turns := simulate(trains, paths, stations, start, trace)
+	printTurns(turns, nil)
+	return turns
+}
+
+func formatTurn(turn []Move) string {
+	var b strings.Builder
+	for _, move := range turn {
+		b.WriteString(move.Train + "-" + move.Station + " ")
+	}
+	return b.String()
+}
+
+// simulate moves the trains along the paths and returns the moves made in
+// every turn. Trains reaching the end are removed from trains.
+func simulate(trains map[string]*Traininfo, paths [][]string, stations map[string]Station, start string, trace *Explainer) [][]Move {
 
 	occupied := make(map[string]bool)
 
@@ -442,22 +1204,39 @@ func Pathbuilder(trains map[string]*Traininfo, paths [][]string, stations map[st
 		}
 	}
 
+	// Trains are tried in the order of their numbers, not the map's order.
+	names := make([]string, 0, len(trains))
+	for name := range trains {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(a, b int) bool {
+		return trainNumber(strings.TrimSuffix(names[a], "-")) < trainNumber(strings.TrimSuffix(names[b], "-"))
+	})
+
 	count := len(trains)
 	departed := len(trains)
-	var turn string
+	var turns [][]Move
+	var turn []Move
 	occupied[start] = true
 	severed := false
+	turnNo := 0
 
 	for count > 0 {
-		for _, path := range paths {
+		turnNo++
+		held := make(map[int]bool)
+		for p, path := range paths {
 			for i, station := range path {
 				if occupied[station] {
-					for name, train := range trains {
+					for _, name := range names {
+						train, ok := trains[name]
+						if !ok {
+							continue
+						}
 						if station == *train.Location {
 							if path[i-1] == path[0] {
 								if station == start {
 									if !severed {
-										turn += name + path[i-1] + " "
+										turn = append(turn, Move{Train: strings.TrimSuffix(name, "-"), Station: path[i-1]})
 										delete(trains, name)
 										count--
 										departed--
@@ -467,17 +1246,24 @@ func Pathbuilder(trains map[string]*Traininfo, paths [][]string, stations map[st
 										continue
 									}
 								}
-								turn += name + path[i-1] + " "
+								turn = append(turn, Move{Train: strings.TrimSuffix(name, "-"), Station: path[i-1]})
 								occupied[*train.Location] = false
 								count--
 								delete(trains, name)
 							} else if !occupied[path[i-1]] {
 								if station == start && (len(path)-shortestpath) > departed {
+									if !held[p] {
+										held[p] = true
+										trace.note("hold", map[string]interface{}{"turn": turnNo, "train": name, "route": forward(path),
+											"routeLength": len(path), "shortest": shortestpath, "departed": departed},
+											"turn %d: %s held at %s, route via %s is %d longer than the shortest and only %d train(s) remain to depart",
+											turnNo, strings.TrimSuffix(name, "-"), start, path[i-1], len(path)-shortestpath, departed)
+									}
 									continue
 								}
 								occupied[*train.Location] = false
 								*train.Location = path[i-1]
-								turn += name + *train.Location + " "
+								turn = append(turn, Move{Train: strings.TrimSuffix(name, "-"), Station: *train.Location})
 								occupied[*train.Location] = true
 								if station == start {
 									departed--
@@ -495,8 +1281,9 @@ func Pathbuilder(trains map[string]*Traininfo, paths [][]string, stations map[st
 				}
 			}
 		}
-		fmt.Println(Blue, turn, Reset)
+		turns = append(turns, turn)
 		severed = false
-		turn = ""
+		turn = nil
 	}
+	return turns
 }
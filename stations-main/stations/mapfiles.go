@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// mapSource is one file taking part in a merged network.
+type mapSource struct {
+	name string
+	data *MapData
+}
+
+// indexedError remembers which source an error belongs to, so errors can be
+// listed file by file and line by line.
+type indexedError struct {
+	source int
+	err    MapError
+}
+
+// loadMap reads one or more map files, following include directives, and
+// merges them into a single network. A single file without includes is parsed
+// exactly as parseMap does. The returned error is only set when one of the
+// given files cannot be read at all; every other problem ends up in Errors.
+func loadMap(paths []string, cfg ParseConfig) (*MapData, error) {
+	return loadSources(paths, cfg, func(path string) (*MapData, error) {
+		return parseFile(path, cfg)
+	})
+}
+
+// parseFile parses a map file as it streams from disk, so that a very large
+// network is never held in memory as text. Anything but a regular file is
+// read whole first, which also gives os.ReadFile's errors for directories.
+func parseFile(path string, cfg ParseConfig) (*MapData, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	if info, err := file.Stat(); err == nil && info.Mode().IsRegular() {
+		return parseSource(file, cfg), nil
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseSource(bytes.NewReader(content), cfg), nil
+}
+
+// loadMapFrom is loadMap with a custom way of reading files, which lets the
+// language server use the unsaved text of open documents.
+func loadMapFrom(paths []string, cfg ParseConfig, readFile func(string) ([]byte, error)) (*MapData, error) {
+	return loadSources(paths, cfg, func(path string) (*MapData, error) {
+		content, err := readFile(path)
+		if err != nil {
+			return nil, err
+		}
+		return parseSource(bytes.NewReader(content), cfg), nil
+	})
+}
+
+// loadSources parses every file of the network once, with parse, and merges
+// them within the limits of cfg.
+func loadSources(paths []string, cfg ParseConfig, parse func(string) (*MapData, error)) (*MapData, error) {
+	var sources []*mapSource
+	var errs []indexedError
+	loaded := make(map[string]bool)
+	active := make(map[string]bool)
+
+	var visit func(path string) error
+	visit = func(path string) error {
+		key, err := filepath.Abs(path)
+		if err != nil {
+			key = filepath.Clean(path)
+		}
+		if loaded[key] {
+			return nil
+		}
+		data, err := parse(path)
+		if err != nil {
+			return err
+		}
+		loaded[key] = true
+		active[key] = true
+		defer delete(active, key)
+
+		src := &mapSource{name: path, data: data}
+		sources = append(sources, src)
+		index := len(sources) - 1
+		for _, inc := range src.data.Includes {
+			target := inc.Path
+			if !filepath.IsAbs(target) {
+				target = filepath.Join(filepath.Dir(path), target)
+			}
+			if abs, err := filepath.Abs(target); err == nil && active[abs] {
+				errs = append(errs, indexedError{index, MapError{File: path, Line: inc.Line,
+					Msg: fmt.Sprintf("include of %s creates a cycle", inc.Path)}})
+				continue
+			}
+			if err := visit(target); err != nil {
+				errs = append(errs, indexedError{index, MapError{File: path, Line: inc.Line,
+					Msg: fmt.Sprintf("unable to include %s: %s", inc.Path, err)}})
+			}
+		}
+		return nil
+	}
+	for _, path := range paths {
+		if err := visit(path); err != nil {
+			return nil, err
+		}
+	}
+	if len(sources) == 1 && len(errs) == 0 {
+		checkMap(sources[0].data)
+		return sources[0].data, nil
+	}
+	return mergeSources(sources, errs, cfg), nil
+}
+
+func mergeSources(sources []*mapSource, errs []indexedError, cfg ParseConfig) *MapData {
+	merged := &MapData{
+		Stations:    make(map[string]Station),
+		Connections: make(map[string][]string),
+		Defined:     make(map[string]int),
+		Tracks:      make(map[string]int),
+	}
+	type origin struct {
+		source int
+		line   int
+	}
+	stationFrom := make(map[string]origin)
+	coordsFrom := make(map[string]string)
+	geoSeen := newGeoGrid(0)
+	trackFrom := make(map[string]origin)
+	sourceLines := make([][]MapLine, len(sources))
+	where := func(o origin) string {
+		return fmt.Sprintf("%s:%d", sources[o.source].name, o.line)
+	}
+
+	for i, src := range sources {
+		data := src.data
+		merged.HasStations = merged.HasStations || data.HasStations
+		merged.HasConnections = merged.HasConnections || data.HasConnections
+		merged.TooLarge = merged.TooLarge || data.TooLarge
+		if i == 0 {
+			merged.Geo, merged.Tolerance, merged.HeaderLine = data.Geo, data.Tolerance, data.HeaderLine
+			geoSeen = newGeoGrid(data.Tolerance)
+		}
+		for _, e := range data.Errors {
+			if e.missing != "" && definedElsewhere(sources, i, e.missing) {
+				// A connection to a station of another file.
+				continue
+			}
+			if e.Line > 0 {
+				e.File = src.name
+				errs = append(errs, indexedError{i, e})
+			}
+		}
+		report := func(line int, format string, args ...interface{}) {
+			errs = append(errs, indexedError{i, MapError{File: src.name, Line: line, Msg: fmt.Sprintf(format, args...)}})
+		}
+		if data.Geo != merged.Geo {
+			report(max(data.HeaderLine, 1), "coordinates do not match %s, every file needs the same coordinates header", sources[0].name)
+			continue
+		}
+
+		sourceLines[i] = data.Lines
+
+		names := make([]string, 0, len(data.Stations))
+		for name := range data.Stations {
+			names = append(names, name)
+		}
+		sort.Slice(names, func(a, b int) bool { return data.Defined[names[a]] < data.Defined[names[b]] })
+		for _, name := range names {
+			st := data.Stations[name]
+			line := data.Defined[name]
+			if prev, exists := stationFrom[name]; exists {
+				old := merged.Stations[name]
+				if !samePlace(old, st) {
+					report(line, "Station %s is defined at %s but %s defines it at %s", name, st.Coordinates(), where(prev), old.Coordinates())
+				}
+				continue
+			}
+			if st.Geo {
+				// Clashes within one file were reported by parseSource.
+				if other, clash := geoSeen.near(st); clash && stationFrom[other.Name].source != i {
+					report(line, "Station %s at %s is within %g metres of station %s at %s (%s)", name, st.Coords, merged.Tolerance, other.Name, other.Coords, where(stationFrom[other.Name]))
+				} else if !clash {
+					geoSeen.add(st)
+				}
+			} else {
+				coords := fmt.Sprintf("%d %d", st.X, st.Y)
+				if owner, taken := coordsFrom[coords]; taken && stationFrom[owner].source != i {
+					report(line, "Station %s tried to occupy coordinates %d,%d which are already occupied by %s (%s)", name, st.X, st.Y, owner, where(stationFrom[owner]))
+				} else if !taken {
+					coordsFrom[coords] = name
+				}
+			}
+			stationFrom[name] = origin{i, line}
+			merged.Stations[name] = st
+			merged.Defined[name] = line
+		}
+
+		tracks := make([]string, 0, len(data.Tracks))
+		for track := range data.Tracks {
+			tracks = append(tracks, track)
+		}
+		sort.Slice(tracks, func(a, b int) bool { return data.Tracks[tracks[a]] < data.Tracks[tracks[b]] })
+		for _, track := range tracks {
+			line := data.Tracks[track]
+			a, b, _ := strings.Cut(track, "-")
+			if prev, exists := trackFrom[track]; exists {
+				report(line, "duplicate line between %s and %s, already defined in %s", a, b, where(prev))
+				continue
+			}
+			trackFrom[track] = origin{i, line}
+			merged.Tracks[track] = line
+			merged.Connections[a] = append(merged.Connections[a], b)
+			merged.Connections[b] = append(merged.Connections[b], a)
+		}
+	}
+
+	// Lines may run over tracks of any file, so they are checked once the
+	// whole network is known.
+	lineFrom := make(map[string]origin)
+	for i, lines := range sourceLines {
+		report := func(line int, format string, args ...interface{}) {
+			errs = append(errs, indexedError{i, MapError{File: sources[i].name, Line: line, Msg: fmt.Sprintf(format, args...)}})
+		}
+		checkLines(lines, merged.Stations, merged.Connections, report)
+		for _, l := range lines {
+			if prev, exists := lineFrom[l.Name]; exists && prev.source != i {
+				report(l.Line, "Line %s defined more than once, already defined in %s", l.Name, where(prev))
+				continue
+			} else if !exists {
+				lineFrom[l.Name] = origin{i, l.Line}
+			}
+			merged.Lines = append(merged.Lines, l)
+		}
+	}
+
+	// Errors of one file stay together and in line order; problems with the
+	// network as a whole come last.
+	sort.SliceStable(errs, func(a, b int) bool {
+		if errs[a].source != errs[b].source {
+			return errs[a].source < errs[b].source
+		}
+		return errs[a].err.Line < errs[b].err.Line
+	})
+	for _, e := range errs {
+		merged.Errors = append(merged.Errors, e.err)
+	}
+	if len(merged.Stations) > cfg.maxStations() && !merged.TooLarge {
+		merged.Errors = append(merged.Errors, MapError{Msg: fmt.Sprintf("Train map exceeded the maximum number(%s) of allowed stations, exiting...", cfg.stationLimit())})
+		merged.TooLarge = true
+	}
+	if !merged.HasConnections {
+		merged.Errors = append(merged.Errors, MapError{Msg: "Train map does not contain connections"})
+	}
+	if !merged.HasStations {
+		merged.Errors = append(merged.Errors, MapError{Msg: "Train map does not contain stations"})
+	}
+	return merged
+}
+
+// definedElsewhere reports whether a source other than the given one defines
+// the station.
+func definedElsewhere(sources []*mapSource, source int, station string) bool {
+	for j, other := range sources {
+		if j != source && hasStation(other.data.Stations, station) {
+			return true
+		}
+	}
+	return false
+}
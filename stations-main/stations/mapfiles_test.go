@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// loadFiles loads a network from in-memory files and counts how often each
+// file is read.
+func loadFiles(t *testing.T, files map[string]string, paths ...string) (*MapData, map[string]int) {
+	t.Helper()
+	reads := make(map[string]int)
+	data, err := loadMapFrom(paths, ParseConfig{}, func(path string) ([]byte, error) {
+		reads[path]++
+		text, ok := files[path]
+		if !ok {
+			return nil, fmt.Errorf("no file %s", path)
+		}
+		return []byte(text), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data, reads
+}
+
+func errorTexts(data *MapData) []string {
+	var texts []string
+	for _, e := range data.Errors {
+		texts = append(texts, e.Error())
+	}
+	return texts
+}
+
+func TestIncludesMergeAcrossFiles(t *testing.T) {
+	files := map[string]string{
+		"main.txt":  "include: north.txt\nstations:\na,0,0\nb,1,0\nconnections:\na-b\nb-c\n",
+		"north.txt": "include: main.txt\nstations:\nc,2,0\nconnections:\nc-a\n",
+	}
+	data, reads := loadFiles(t, files, "main.txt")
+	want := []string{"Error: north.txt:1: include of main.txt creates a cycle"}
+	if got := errorTexts(data); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got errors %q, want %q", got, want)
+	}
+	for name, n := range reads {
+		if n != 1 {
+			t.Errorf("%s was read %d times", name, n)
+		}
+	}
+	var tracks []string
+	for track := range data.Tracks {
+		tracks = append(tracks, track)
+	}
+	sort.Strings(tracks)
+	if len(data.Stations) != 3 || !reflect.DeepEqual(tracks, []string{"a-b", "a-c", "b-c"}) {
+		t.Fatalf("merged %d stations and tracks %v", len(data.Stations), tracks)
+	}
+}
+
+func TestIncludeConflictsNameBothFiles(t *testing.T) {
+	files := map[string]string{
+		"main.txt": "include: other.txt\nstations:\na,0,0\nb,1,0\nconnections:\na-b\n",
+		"other.txt": "stations:\n" +
+			"a,5,5\n" + // line 2: a moved
+			"c,1,0\n" + // line 3: on b's coordinates
+			"d,2,2\n" +
+			"connections:\n" +
+			"b-a\n" + // line 6: the track of main.txt again
+			"c-d\n" +
+			"d-x\n", // line 8: x is nowhere
+	}
+	data, _ := loadFiles(t, files, "main.txt")
+	want := []string{
+		"Error: other.txt:2: Station a is defined at 5,5 but main.txt:3 defines it at 0,0",
+		"Error: other.txt:3: Station c tried to occupy coordinates 1,0 which are already occupied by b (main.txt:4)",
+		"Error: other.txt:6: duplicate line between a and b, already defined in main.txt:6",
+		"Error: other.txt:8: Tried to make connection to x, which is not specified within stations section",
+	}
+	if got := errorTexts(data); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got errors\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestMissingIncludeIsReported(t *testing.T) {
+	files := map[string]string{"main.txt": "stations:\na,0,0\nb,1,0\nconnections:\na-b\ninclude: gone.txt\n"}
+	data, _ := loadFiles(t, files, "main.txt")
+	want := []string{"Error: main.txt:6: unable to include gone.txt: no file gone.txt"}
+	if got := errorTexts(data); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got errors %q, want %q", got, want)
+	}
+}
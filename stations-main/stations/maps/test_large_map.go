@@ -36,7 +36,7 @@ func main() {
 	tmpFile.Close()
 
 	// Prepare the command to run the main program
-	cmd := exec.Command("go", "run", "main.go", tmpFile.Name(), "station_0", "station_10000", "1")
+	cmd := exec.Command("go", "run", ".", tmpFile.Name(), "station_0", "station_10000", "1")
 
 	// Capture stderr
 	var stderr bytes.Buffer
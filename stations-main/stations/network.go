@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Network is an in-memory train map that can be changed after loading. Closed
+// tracks are kept aside so they can be opened again later.
+type Network struct {
+	Stations    map[string]Station
+	Connections map[string][]string
+	Closed      map[string]bool // keyed by trackKey
+	Tolerance   float64         // metres within which geo stations clash
+	Lines       []MapLine
+}
+
+func newNetwork(stations map[string]Station, connections map[string][]string) *Network {
+	stations, connections = copyNetwork(stations, connections)
+	return &Network{Stations: stations, Connections: connections, Closed: make(map[string]bool)}
+}
+
+// loadNetwork parses a map file and refuses it if parseMap reported errors.
+func loadNetwork(mapfile string, cfg ParseConfig) (*Network, error) {
+	data, err := loadMap([]string{mapfile}, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if len(data.Errors) > 0 {
+		msgs := make([]string, len(data.Errors))
+		for i, e := range data.Errors {
+			msgs[i] = e.Error()
+		}
+		return nil, fmt.Errorf("%s", strings.Join(msgs, "\n"))
+	}
+	network := newNetwork(data.Stations, data.Connections)
+	network.Tolerance = data.Tolerance
+	network.Lines = data.Lines
+	return network, nil
+}
+
+// Geo reports whether the stations have latitude and longitude.
+func (n *Network) Geo() bool {
+	for _, st := range n.Stations {
+		return st.Geo
+	}
+	return false
+}
+
+// trackKey names a track the same way regardless of direction.
+func trackKey(a, b string) string {
+	if a > b {
+		a, b = b, a
+	}
+	return a + "-" + b
+}
+
+func (n *Network) requireStation(name string) error {
+	if _, ok := n.Stations[name]; !ok {
+		return fmt.Errorf("station %s does not exist", name)
+	}
+	return nil
+}
+
+func (n *Network) AddStation(name string, x, y int) error {
+	if !validStationName(name) {
+		return fmt.Errorf("Station (%s) should be composed by only lowercase, numbers and underscore characters", name)
+	}
+	if _, exists := n.Stations[name]; exists {
+		return fmt.Errorf("Station %s defined more than once", name)
+	}
+	if n.Geo() {
+		return fmt.Errorf("stations of a geo map need a latitude and longitude, edit the map file instead")
+	}
+	if x < 0 || y < 0 {
+		return fmt.Errorf("Station %s contains negative coordinates", name)
+	}
+	for _, other := range n.Stations {
+		if other.X == x && other.Y == y {
+			return fmt.Errorf("Station %s tried to occupy coordinates %d,%d which are already occupied", name, x, y)
+		}
+	}
+	n.Stations[name] = Station{Name: name, X: x, Y: y}
+	return nil
+}
+
+func (n *Network) Connect(a, b string) error {
+	if err := n.requireStation(a); err != nil {
+		return err
+	}
+	if err := n.requireStation(b); err != nil {
+		return err
+	}
+	if a == b {
+		return fmt.Errorf("cannot connect %s to itself", a)
+	}
+	if contains(n.Connections[a], b) || n.Closed[trackKey(a, b)] {
+		return fmt.Errorf("duplicate line between %s and %s", a, b)
+	}
+	n.Connections[a] = append(n.Connections[a], b)
+	n.Connections[b] = append(n.Connections[b], a)
+	return nil
+}
+
+// Close takes a track out of service without forgetting it.
+func (n *Network) Close(a, b string) error {
+	if !contains(n.Connections[a], b) {
+		if n.Closed[trackKey(a, b)] {
+			return fmt.Errorf("track %s is already closed", trackKey(a, b))
+		}
+		return fmt.Errorf("no track between %s and %s", a, b)
+	}
+	n.Connections[a] = sever(n.Connections[a], b)
+	n.Connections[b] = sever(n.Connections[b], a)
+	n.Closed[trackKey(a, b)] = true
+	return nil
+}
+
+func (n *Network) Open(a, b string) error {
+	if !n.Closed[trackKey(a, b)] {
+		return fmt.Errorf("track %s is not closed", trackKey(a, b))
+	}
+	delete(n.Closed, trackKey(a, b))
+	return n.Connect(a, b)
+}
+
+// Tracks lists every open track once, sorted.
+func (n *Network) Tracks() []string {
+	var tracks []string
+	for a, neighbors := range n.Connections {
+		for _, b := range neighbors {
+			if a < b {
+				tracks = append(tracks, trackKey(a, b))
+			}
+		}
+	}
+	sort.Strings(tracks)
+	return tracks
+}
+
+func (n *Network) StationNames() []string {
+	names := make([]string, 0, len(n.Stations))
+	for name := range n.Stations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Save writes the network in the map file format. Closed tracks are written as
+// comments so that they survive a round trip through a text editor.
+func (n *Network) Save(w io.Writer) error {
+	var b strings.Builder
+	if n.Geo() {
+		b.WriteString("coordinates: geo\n")
+		if n.Tolerance > 0 {
+			fmt.Fprintf(&b, "tolerance: %g\n", n.Tolerance)
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString("stations:\n")
+	for _, name := range n.StationNames() {
+		st := n.Stations[name]
+		fmt.Fprintf(&b, "%s,%s\n", st.Name, st.Coordinates())
+	}
+	b.WriteString("\nconnections:\n")
+	for _, track := range n.Tracks() {
+		b.WriteString(track + "\n")
+	}
+	closed := make([]string, 0, len(n.Closed))
+	for track := range n.Closed {
+		closed = append(closed, track)
+	}
+	sort.Strings(closed)
+	for _, track := range closed {
+		b.WriteString("# closed: " + track + "\n")
+	}
+	if len(n.Lines) > 0 {
+		b.WriteString("\nlines:\n")
+		for _, l := range n.Lines {
+			fmt.Fprintf(&b, "%s,%s,%s\n", l.Name, l.Colour, strings.Join(l.Stations, ","))
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
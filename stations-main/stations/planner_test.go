@@ -0,0 +1,524 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// randomMap writes a connected network of n stations with unique coordinates
+// and some extra tracks on top of a random spanning tree.
+func randomMap(rng *rand.Rand, n int) string {
+	var b strings.Builder
+	b.WriteString("stations:\n")
+	used := make(map[[2]int]bool)
+	for i := 0; i < n; i++ {
+		for {
+			x, y := rng.Intn(3*n), rng.Intn(3*n)
+			if !used[[2]int{x, y}] {
+				used[[2]int{x, y}] = true
+				fmt.Fprintf(&b, "s%d,%d,%d\n", i, x, y)
+				break
+			}
+		}
+	}
+	b.WriteString("connections:\n")
+	tracks := make(map[string]bool)
+	connect := func(i, j int) {
+		key := trackKey(fmt.Sprint("s", i), fmt.Sprint("s", j))
+		if i == j || tracks[key] {
+			return
+		}
+		tracks[key] = true
+		fmt.Fprintf(&b, "s%d-s%d\n", i, j)
+	}
+	for i := 1; i < n; i++ {
+		connect(i, rng.Intn(i))
+	}
+	for k := rng.Intn(2 * n); k > 0; k-- {
+		connect(rng.Intn(n), rng.Intn(n))
+	}
+	return b.String()
+}
+
+// randomCase is a generated network with a start, an end and a number of
+// trains.
+type randomCase struct {
+	text       string
+	data       *MapData
+	start, end string
+	trains     int
+}
+
+func randomCases(t *testing.T, count int) []randomCase {
+	rng := rand.New(rand.NewSource(1))
+	var cases []randomCase
+	for len(cases) < count {
+		n := 2 + rng.Intn(14)
+		text := randomMap(rng, n)
+		data := parseMap(strings.NewReader(text), ParseConfig{})
+		if len(data.Errors) > 0 {
+			t.Fatalf("generated map has errors %v:\n%s", data.Errors, text)
+		}
+		start := fmt.Sprint("s", rng.Intn(n))
+		end := fmt.Sprint("s", rng.Intn(n))
+		if start == end {
+			continue
+		}
+		cases = append(cases, randomCase{text: text, data: data, start: start, end: end, trains: 1 + rng.Intn(12)})
+	}
+	return cases
+}
+
+// checkSchedule verifies the movement rules: every move follows an existing
+// connection, no two trains share a station other than start and end at the
+// end of a turn, no two trains use the same track in a turn, and every train
+// reaches the end station.
+func checkSchedule(connections map[string][]string, origins map[string]string, end string, turns [][]Move) error {
+	location := make(map[string]string)
+	for train, origin := range origins {
+		location[train] = origin
+	}
+	terminal := map[string]bool{end: true}
+	for _, origin := range origins {
+		terminal[origin] = true
+	}
+	for n, turn := range turns {
+		tracks := make(map[string]string)
+		for _, move := range turn {
+			from, ok := location[move.Train]
+			if !ok {
+				return fmt.Errorf("turn %d: unknown train %s", n+1, move.Train)
+			}
+			if from == end {
+				return fmt.Errorf("turn %d: %s moved on from the end station", n+1, move.Train)
+			}
+			if !contains(connections[from], move.Station) {
+				return fmt.Errorf("turn %d: %s moved from %s to %s without a connection", n+1, move.Train, from, move.Station)
+			}
+			key := trackKey(from, move.Station)
+			if other, used := tracks[key]; used {
+				return fmt.Errorf("turn %d: %s and %s both used track %s", n+1, other, move.Train, key)
+			}
+			tracks[key] = move.Train
+			location[move.Train] = move.Station
+		}
+		held := make(map[string]string)
+		for train, station := range location {
+			if terminal[station] {
+				continue
+			}
+			if other, taken := held[station]; taken {
+				return fmt.Errorf("turn %d: %s and %s are both at %s", n+1, other, train, station)
+			}
+			held[station] = train
+		}
+	}
+	for train, station := range location {
+		if station != end {
+			return fmt.Errorf("%s ended at %s instead of %s", train, station, end)
+		}
+	}
+	return nil
+}
+
+func planAndSimulate(c randomCase) ([][]string, [][]Move, error) {
+	paths, _, err := planRoutes(context.Background(), c.data.Stations, c.data.Connections, c.start, c.end, c.trains, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return paths, simulate(Trainnames(c.trains, c.start), paths, c.data.Stations, c.start, nil), nil
+}
+
+func TestScheduleFollowsMovementRules(t *testing.T) {
+	for i, c := range randomCases(t, 300) {
+		_, turns, err := planAndSimulate(c)
+		if err != nil {
+			t.Fatalf("case %d: %s\n%s", i, err, c.text)
+		}
+		if err := checkSchedule(c.data.Connections, sameOrigin(c.trains, c.start), c.end, turns); err != nil {
+			t.Fatalf("case %d, %d trains from %s to %s: %s\n%s", i, c.trains, c.start, c.end, err, c.text)
+		}
+	}
+}
+
+func TestTrainSchedulerFollowsMovementRules(t *testing.T) {
+	for i, c := range randomCases(t, 300) {
+		paths, _, err := planRoutes(context.Background(), c.data.Stations, c.data.Connections, c.start, c.end, c.trains, nil)
+		if err != nil {
+			t.Fatalf("case %d: %s", i, err)
+		}
+		for _, cfg := range []ScheduleConfig{{}, {Block: true}} {
+			trains := startTrains(c.trains, c.start)
+			order, _, ok := assignRoutes(trains, forwardAll(paths), cfg)
+			if !ok {
+				t.Fatalf("case %d: no route for some trains", i)
+			}
+			turns, err := runSchedule(order, cfg)
+			if err != nil {
+				t.Fatalf("case %d: %s", i, err)
+			}
+			if err := checkSchedule(c.data.Connections, sameOrigin(c.trains, c.start), c.end, turns); err != nil {
+				t.Fatalf("case %d (%+v), %d trains from %s to %s: %s\n%s", i, cfg, c.trains, c.start, c.end, err, c.text)
+			}
+		}
+	}
+}
+
+func TestEventsMatchScheduleWithUnitTimings(t *testing.T) {
+	unit := Timings{Tracks: map[string]float64{}, Dwell: map[string]float64{}}
+	for i, c := range randomCases(t, 300) {
+		paths, _, err := planRoutes(context.Background(), c.data.Stations, c.data.Connections, c.start, c.end, c.trains, nil)
+		if err != nil {
+			t.Fatalf("case %d: %s", i, err)
+		}
+		for _, cfg := range []ScheduleConfig{{}, {Block: true}} {
+			turned, timed := startTrains(c.trains, c.start), startTrains(c.trains, c.start)
+			turned, _, _ = assignRoutes(turned, forwardAll(paths), cfg)
+			timed, _, _ = assignRoutes(timed, forwardAll(paths), cfg)
+			want, err := runSchedule(turned, cfg)
+			if err != nil {
+				t.Fatalf("case %d: %s", i, err)
+			}
+			moves, err := runEvents(timed, cfg, unit)
+			if err != nil {
+				t.Fatalf("case %d: %s", i, err)
+			}
+			if got := timedTurns(moves); !reflect.DeepEqual(got, want) {
+				t.Fatalf("case %d (%+v): events gave\n%v\nturns gave\n%v", i, cfg, got, want)
+			}
+		}
+	}
+}
+
+// captureStdout returns what f prints.
+func captureStdout(t *testing.T, f func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+	out := make(chan string)
+	go func() {
+		text, _ := io.ReadAll(r)
+		out <- string(text)
+	}()
+	f()
+	w.Close()
+	return <-out
+}
+
+// A continuous run prints its turns as a normal run does, so whatever reads
+// the output of one reads the other; --timeline prints the times instead.
+func TestContinuousRunPrintsTurns(t *testing.T) {
+	data, err := loadMap([]string{"maps/london.txt"}, ParseConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths, _, err := planRoutes(context.Background(), data.Stations, data.Connections, "waterloo", "st_pancras", 4, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unit := Timings{Tracks: map[string]float64{}, Dwell: map[string]float64{}}
+	turned := captureStdout(t, func() {
+		runTrains(startTrains(4, "waterloo"), forwardAll(paths), ScheduleConfig{}, nil)
+	})
+	continuous := captureStdout(t, func() {
+		runContinuous(startTrains(4, "waterloo"), forwardAll(paths), ScheduleConfig{}, unit, nil, false)
+	})
+	if continuous != turned {
+		t.Fatalf("continuous run printed\n%s\nnormal run printed\n%s", continuous, turned)
+	}
+	timeline := captureStdout(t, func() {
+		runContinuous(startTrains(4, "waterloo"), forwardAll(paths), ScheduleConfig{}, unit, nil, true)
+	})
+	lines := strings.Split(strings.TrimSpace(timeline), "\n")
+	if len(lines) != 4 || !strings.Contains(lines[0], " 1: T1-") || lines[3] != "All trains arrived at 3" {
+		t.Fatalf("timeline printed\n%s", timeline)
+	}
+}
+
+// checkTimeline verifies a continuous run: a station other than start and end
+// holds one train from the time one leaves for it until it leaves again, a
+// track takes one train at a time for its time or the headway, whichever is
+// longer, trains stop for the dwell time and every train reaches the end.
+func checkTimeline(connections map[string][]string, start, end string, trains int, tm Timings, moves []TimedMove) error {
+	type span struct {
+		from, to float64
+		train    string
+	}
+	held := make(map[string][]span)
+	tracks := make(map[string][]span)
+	arrived := make(map[string]float64)
+	location := make(map[string]string)
+	byDepart := append([]TimedMove(nil), moves...)
+	sort.SliceStable(byDepart, func(a, b int) bool { return byDepart[a].Depart < byDepart[b].Depart })
+	for _, m := range byDepart {
+		from := location[m.Train]
+		if from == "" {
+			from = start
+		}
+		if from != m.From || !contains(connections[m.From], m.Station) {
+			return fmt.Errorf("%s moved from %s to %s at %g, it was at %s", m.Train, m.From, m.Station, m.Depart, from)
+		}
+		if from != start && m.Depart < arrived[m.Train]+tm.dwell(from) {
+			return fmt.Errorf("%s left %s at %g before its dwell was over", m.Train, from, m.Depart)
+		}
+		if m.Arrive-m.Depart != tm.track(m.From, m.Station) {
+			return fmt.Errorf("%s took %g to run %s-%s", m.Train, m.Arrive-m.Depart, m.From, m.Station)
+		}
+		for k, s := range held[from] {
+			if s.train == m.Train && math.IsInf(s.to, 1) {
+				held[from][k].to = m.Depart
+			}
+		}
+		if m.Station != end {
+			held[m.Station] = append(held[m.Station], span{m.Depart, math.Inf(1), m.Train})
+		}
+		tracks[trackKey(m.From, m.Station)] = append(tracks[trackKey(m.From, m.Station)], span{m.Depart, m.Depart + math.Max(m.Arrive-m.Depart, tm.Headway), m.Train})
+		location[m.Train] = m.Station
+		arrived[m.Train] = m.Arrive
+	}
+	for what, all := range map[string]map[string][]span{"station": held, "track": tracks} {
+		for name, spans := range all {
+			for k := 1; k < len(spans); k++ {
+				if spans[k].from < spans[k-1].to {
+					return fmt.Errorf("%s and %s overlap on %s %s", spans[k-1].train, spans[k].train, what, name)
+				}
+			}
+		}
+	}
+	for i := 1; i <= trains; i++ {
+		if train := fmt.Sprint("T", i); location[train] != end {
+			return fmt.Errorf("%s ended at %s instead of %s", train, location[train], end)
+		}
+	}
+	return nil
+}
+
+func TestEventsFollowTimings(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for i, c := range randomCases(t, 300) {
+		paths, _, err := planRoutes(context.Background(), c.data.Stations, c.data.Connections, c.start, c.end, c.trains, nil)
+		if err != nil {
+			t.Fatalf("case %d: %s", i, err)
+		}
+		tm := Timings{Tracks: map[string]float64{}, Dwell: map[string]float64{}, Headway: float64(rng.Intn(3)) / 2, Stop: float64(rng.Intn(2)) / 4}
+		for _, track := range (&Network{Stations: c.data.Stations, Connections: c.data.Connections}).Tracks() {
+			tm.Tracks[track] = float64(1+rng.Intn(8)) / 4
+		}
+		for name := range c.data.Stations {
+			if rng.Intn(3) == 0 {
+				tm.Dwell[name] = float64(rng.Intn(4)) / 2
+			}
+		}
+		// Both directions of a track are one resource here, which the check
+		// relies on.
+		cfg := ScheduleConfig{Block: true}
+		trains := startTrains(c.trains, c.start)
+		trains, _, _ = assignRoutes(trains, forwardAll(paths), cfg)
+		moves, err := runEvents(trains, cfg, tm)
+		if err != nil {
+			t.Fatalf("case %d: %s", i, err)
+		}
+		if err := checkTimeline(c.data.Connections, c.start, c.end, c.trains, tm, moves); err != nil {
+			t.Fatalf("case %d, %d trains from %s to %s: %s\n%s", i, c.trains, c.start, c.end, err, c.text)
+		}
+	}
+}
+
+// checkLongTrains replays the turns of trains with a length and verifies that
+// no two trains take the same station or track at the end of a turn and that
+// no train runs a track another one takes when it moves. Moves are replayed in
+// order, as a train may follow one that moved on earlier in the turn.
+func checkLongTrains(trains []*Train, terminal map[string]bool, turns [][]Move) error {
+	pos := make(map[string]int, len(trains))
+	byName := make(map[string]*Train, len(trains))
+	for _, t := range trains {
+		byName[t.Name] = t
+	}
+	taken := func() map[string]string {
+		held := make(map[string]string)
+		for _, t := range trains {
+			for _, b := range blocks(t.Route, pos[t.Name], t.Length, terminal) {
+				held[b] = t.Name
+			}
+		}
+		return held
+	}
+	for n, turn := range turns {
+		for _, move := range turn {
+			t := byName[move.Train]
+			track := trackKey(t.Route[pos[t.Name]], move.Station)
+			if holder := taken()[track]; holder != "" && holder != t.Name {
+				return fmt.Errorf("turn %d: %s ran %s, which %s takes", n+1, t.Name, track, holder)
+			}
+			pos[t.Name]++
+		}
+		held := make(map[string]string)
+		for _, t := range trains {
+			for _, b := range blocks(t.Route, pos[t.Name], t.Length, terminal) {
+				if other, ok := held[b]; ok {
+					return fmt.Errorf("turn %d: %s and %s both take %s", n+1, other, t.Name, b)
+				}
+				held[b] = t.Name
+			}
+		}
+	}
+	return nil
+}
+
+func TestLongTrainsKeepTheirBlocks(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for i, c := range randomCases(t, 300) {
+		paths, _, err := planRoutes(context.Background(), c.data.Stations, c.data.Connections, c.start, c.end, c.trains, nil)
+		if err != nil {
+			t.Fatalf("case %d: %s", i, err)
+		}
+		routes := forwardAll(paths)
+		for run, cfg := range []ScheduleConfig{{}, {Block: true}, {}} {
+			trains := startTrains(c.trains, c.start)
+			for k, train := range trains {
+				train.Length = 1 + rng.Intn(6)
+				// The last run hands out the routes without planning, so
+				// the simulator alone keeps the trains apart.
+				train.Route = routes[k%len(routes)]
+			}
+			if run < 2 {
+				var ok bool
+				if trains, _, ok = assignRoutes(trains, routes, cfg); !ok {
+					t.Fatalf("case %d: no route for some trains", i)
+				}
+			}
+			turns, err := runSchedule(trains, cfg)
+			if err != nil {
+				t.Fatalf("case %d: %s", i, err)
+			}
+			if err := checkSchedule(c.data.Connections, sameOrigin(c.trains, c.start), c.end, turns); err != nil {
+				t.Fatalf("case %d (%+v): %s\n%s", i, cfg, err, c.text)
+			}
+			if err := checkLongTrains(trains, map[string]bool{c.start: true, c.end: true}, turns); err != nil {
+				t.Fatalf("case %d (%+v), %d trains from %s to %s: %s\n%s", i, cfg, c.trains, c.start, c.end, err, c.text)
+			}
+		}
+	}
+}
+
+func TestPlannerLeavesInputUnchanged(t *testing.T) {
+	for i, c := range randomCases(t, 200) {
+		before := parseMap(strings.NewReader(c.text), ParseConfig{})
+		if _, _, err := planAndSimulate(c); err != nil {
+			t.Fatalf("case %d: %s", i, err)
+		}
+		if !reflect.DeepEqual(before.Stations, c.data.Stations) || !reflect.DeepEqual(before.Connections, c.data.Connections) {
+			t.Fatalf("case %d: planning from %s to %s changed the network\n%s", i, c.start, c.end, c.text)
+		}
+	}
+}
+
+func TestPlannerIgnoresMapOrder(t *testing.T) {
+	for i, c := range randomCases(t, 100) {
+		wantPaths, wantTurns, err := planAndSimulate(c)
+		if err != nil {
+			t.Fatalf("case %d: %s", i, err)
+		}
+		// Go visits maps in a different order every time, so planning again,
+		// also from freshly built maps, must give the same schedule.
+		for run := 0; run < 5; run++ {
+			c.data = parseMap(strings.NewReader(c.text), ParseConfig{})
+			paths, turns, err := planAndSimulate(c)
+			if err != nil {
+				t.Fatalf("case %d: %s", i, err)
+			}
+			if !reflect.DeepEqual(paths, wantPaths) || !reflect.DeepEqual(turns, wantTurns) {
+				t.Fatalf("case %d, %d trains from %s to %s: routes %v then %v, %d turns then %d\n%s",
+					i, c.trains, c.start, c.end, forwardAll(wantPaths), forwardAll(paths), len(wantTurns), len(turns), c.text)
+			}
+		}
+	}
+}
+
+func TestDelayedScheduleFollowsMovementRules(t *testing.T) {
+	model := delayModel{Kind: "uniform", P: 0.3, N: 3}
+	for i, c := range randomCases(t, 200) {
+		paths, _, err := planRoutes(context.Background(), c.data.Stations, c.data.Connections, c.start, c.end, c.trains, nil)
+		if err != nil {
+			t.Fatalf("case %d: %s", i, err)
+		}
+		trains := startTrains(c.trains, c.start)
+		trains, _, ok := assignRoutes(trains, forwardAll(paths), ScheduleConfig{})
+		if !ok {
+			t.Fatalf("case %d: no route for some trains", i)
+		}
+		run := &delayRun{model: model, rng: rand.New(rand.NewSource(int64(i))), held: make(map[string][2]int), ready: make(map[int]map[string]string)}
+		turns, err := runSchedule(trains, ScheduleConfig{Hold: run.hold})
+		if err != nil {
+			t.Fatalf("case %d: %s", i, err)
+		}
+		if err := checkSchedule(c.data.Connections, sameOrigin(c.trains, c.start), c.end, turns); err != nil {
+			t.Fatalf("case %d, %d trains from %s to %s: %s\n%s", i, c.trains, c.start, c.end, err, c.text)
+		}
+	}
+}
+
+func TestEveryPlannerFollowsMovementRules(t *testing.T) {
+	for i, c := range randomCases(t, 200) {
+		for _, p := range planners {
+			var paths [][]string
+			p.Plan(context.Background(), c.data.Stations, c.data.Connections, c.start, c.end, c.trains, func(routes [][]string) { paths = routes }, nil)
+			if len(paths) == 0 {
+				if p.Name() == "greedy" {
+					continue // replanning may avoid every route, planRoutes has the others then
+				}
+				t.Fatalf("case %d: %s found no routes from %s to %s\n%s", i, p.Name(), c.start, c.end, c.text)
+			}
+			turns := simulate(Trainnames(c.trains, c.start), paths, c.data.Stations, c.start, nil)
+			if err := checkSchedule(c.data.Connections, sameOrigin(c.trains, c.start), c.end, turns); err != nil {
+				t.Fatalf("case %d, %s, %d trains from %s to %s: %s\n%s", i, p.Name(), c.trains, c.start, c.end, err, c.text)
+			}
+		}
+	}
+}
+
+// planRoutes has to keep the route set that really takes the fewest turns,
+// not the one the estimate likes best.
+func TestPlanRoutesPicksFewestScheduledTurns(t *testing.T) {
+	for i, c := range randomCases(t, 200) {
+		fewest := -1
+		for _, p := range routePlanners(len(c.data.Stations)) {
+			var paths [][]string
+			p.Plan(context.Background(), c.data.Stations, c.data.Connections, c.start, c.end, c.trains, func(routes [][]string) { paths = routes }, nil)
+			if paths == nil {
+				continue
+			}
+			if turns := scheduledTurns(paths, c.start, c.trains); fewest == -1 || turns < fewest {
+				fewest = turns
+			}
+		}
+		trace := &Explainer{}
+		paths, _, err := planRoutes(context.Background(), c.data.Stations, c.data.Connections, c.start, c.end, c.trains, trace)
+		if err != nil {
+			t.Fatalf("case %d: %s", i, err)
+		}
+		if turns := scheduledTurns(paths, c.start, c.trains); turns != fewest {
+			t.Fatalf("case %d, %d trains from %s to %s: chose routes taking %d turns, a planner found %d\n%s", i, c.trains, c.start, c.end, turns, fewest, c.text)
+		}
+		kinds := make(map[string]bool)
+		for _, ev := range trace.events {
+			kinds[ev.Kind] = true
+		}
+		if !kinds["round"] || !kinds["strategy"] {
+			t.Fatalf("case %d: the trace lacks the planners' events: %v", i, kinds)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const replHelp = `Commands:
+  path <from> <to>              shortest path between two stations
+  routes <from> <to>            route set the planner would use
+  simulate <from> <to> <n>      move n trains and print every turn
+  close <a>-<b>                 take a track out of service
+  open <a>-<b>                  put a closed track back into service
+  add station <name> <x> <y>    add a new station
+  connect <a> <b>               add a track between two stations
+  neighbors <station>           list stations connected to a station
+  list                          list stations and tracks
+  save <file>                   write the network as a map file
+  help                          show this text
+  quit                          leave the repl`
+
+func replMain(mapfile string, cfg ParseConfig) {
+	network, err := loadNetwork(mapfile, cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Println(Red, "Please fix listed errors", Reset)
+		os.Exit(0)
+	}
+	fmt.Printf("Loaded %d stations and %d tracks from %s. Type help for commands.\n", len(network.Stations), len(network.Tracks()), mapfile)
+	runRepl(network, os.Stdin, os.Stdout)
+}
+
+func runRepl(network *Network, in io.Reader, out io.Writer) {
+	scanner := bufio.NewScanner(in)
+	for {
+		fmt.Fprint(out, "> ")
+		if !scanner.Scan() {
+			fmt.Fprintln(out)
+			return
+		}
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if fields[0] == "quit" || fields[0] == "exit" {
+			return
+		}
+		if err := replCommand(network, fields, out); err != nil {
+			fmt.Fprintf(out, "%sError: %s%s\n", Red, err, Reset)
+		}
+	}
+}
+
+func replCommand(network *Network, fields []string, out io.Writer) error {
+	args := fields[1:]
+	switch fields[0] {
+	case "help":
+		fmt.Fprintln(out, replHelp)
+	case "path":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: path <from> <to>")
+		}
+		path, err := shortestPath(network, args[0], args[1])
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "%s (%d stops)\n", strings.Join(path, " -> "), len(path)-1)
+	case "routes":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: routes <from> <to>")
+		}
+		paths, err := networkRoutes(network, args[0], args[1], 0)
+		if err != nil {
+			return err
+		}
+		for i, path := range paths {
+			fmt.Fprintf(out, "%d: %s (%d stops)\n", i+1, strings.Join(forward(path), " -> "), len(path)-1)
+		}
+	case "simulate":
+		if len(args) != 3 {
+			return fmt.Errorf("usage: simulate <from> <to> <n>")
+		}
+		traincount, err := strconv.Atoi(args[2])
+		if err != nil || traincount < 1 {
+			return fmt.Errorf("unable to convert train numbers(%s) to a positive integer", args[2])
+		}
+		paths, err := networkRoutes(network, args[0], args[1], traincount)
+		if err != nil {
+			return err
+		}
+		Pathbuilder(Trainnames(traincount, args[0]), paths, network.Stations, args[0], nil)
+	case "close", "open":
+		if len(args) != 1 || !strings.Contains(args[0], "-") {
+			return fmt.Errorf("usage: %s <a>-<b>", fields[0])
+		}
+		a, b, _ := strings.Cut(args[0], "-")
+		if fields[0] == "close" {
+			return network.Close(a, b)
+		}
+		return network.Open(a, b)
+	case "add":
+		if len(args) != 4 || args[0] != "station" {
+			return fmt.Errorf("usage: add station <name> <x> <y>")
+		}
+		x, errX := strconv.Atoi(args[2])
+		y, errY := strconv.Atoi(args[3])
+		if errX != nil || errY != nil {
+			return fmt.Errorf("coordinates of %s must be integers", args[1])
+		}
+		return network.AddStation(args[1], x, y)
+	case "connect":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: connect <a> <b>")
+		}
+		return network.Connect(args[0], args[1])
+	case "neighbors":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: neighbors <station>")
+		}
+		if err := network.requireStation(args[0]); err != nil {
+			return err
+		}
+		fmt.Fprintln(out, strings.Join(network.Connections[args[0]], " "))
+	case "list":
+		for _, name := range network.StationNames() {
+			st := network.Stations[name]
+			fmt.Fprintf(out, "%s,%s\n", st.Name, st.Coordinates())
+		}
+		for _, track := range network.Tracks() {
+			fmt.Fprintln(out, track)
+		}
+	case "save":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: save <file>")
+		}
+		file, err := os.Create(args[0])
+		if err != nil {
+			return err
+		}
+		if err := network.Save(file); err != nil {
+			file.Close()
+			return err
+		}
+		return file.Close()
+	default:
+		return fmt.Errorf("unknown command %s, type help for a list", fields[0])
+	}
+	return nil
+}
+
+func checkEndpoints(network *Network, start, end string) error {
+	if err := network.requireStation(start); err != nil {
+		return err
+	}
+	return network.requireStation(end)
+}
+
+func networkRoutes(network *Network, start, end string, traincount int) ([][]string, error) {
+	if err := checkEndpoints(network, start, end); err != nil {
+		return nil, err
+	}
+	paths, _, err := planRoutes(context.Background(), network.Stations, network.Connections, start, end, traincount, nil)
+	return paths, err
+}
+
+// shortestPath runs a single Dijkstra search and returns the path from start
+// to end.
+func shortestPath(network *Network, start, end string) ([]string, error) {
+	if err := checkEndpoints(network, start, end); err != nil {
+		return nil, err
+	}
+	if start == end {
+		return nil, fmt.Errorf("Start and end stations are same (%s)", start)
+	}
+	if !reachable(network.Stations, network.Connections, start, end) {
+		return nil, fmt.Errorf("no valid path between %s and %s", start, end)
+	}
+	stations, connections := copyNetwork(network.Stations, network.Connections)
+	return forward(Dijkstra(stations, connections, start, end, nil, nil)), nil
+}
+
+// forward returns a copy of a planner path in travelling order. Dijkstra
+// builds its paths from the end station back to the start.
+func forward(path []string) []string {
+	result := make([]string, len(path))
+	for i, station := range path {
+		result[len(path)-1-i] = station
+	}
+	return result
+}
+
+func forwardAll(paths [][]string) [][]string {
+	result := make([][]string, len(paths))
+	for i, path := range paths {
+		result[i] = forward(path)
+	}
+	return result
+}
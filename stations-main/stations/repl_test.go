@@ -0,0 +1,109 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Every step runs one repl command on the same network; after the last one
+// the network is saved with save, parsed again and compared.
+func TestReplEditsSurviveSave(t *testing.T) {
+	data := parseMap(strings.NewReader(watchMap), ParseConfig{})
+	network := newNetwork(data.Stations, data.Connections)
+	saved := filepath.Join(t.TempDir(), "saved.txt")
+	for i, step := range []struct {
+		command string
+		out     string // what the command prints, when it prints
+		err     string // part of the error, when it fails
+	}{
+		{"neighbors waterloo", "victoria euston\n", ""},
+		{"close waterloo-victoria", "", ""},
+		{"close victoria-waterloo", "", "already closed"},
+		{"close waterloo-st_pancras", "", "no track between waterloo and st_pancras"},
+		{"neighbors waterloo", "euston\n", ""},
+		{"path waterloo victoria", "waterloo -> euston -> st_pancras -> victoria (3 stops)\n", ""},
+		{"connect waterloo victoria", "", "duplicate line"},
+		{"open waterloo-victoria", "", ""},
+		{"open waterloo-victoria", "", "not closed"},
+		{"path waterloo victoria", "waterloo -> victoria (1 stops)\n", ""},
+		{"add station bank 8 8", "", ""},
+		{"add station bank 9 9", "", "defined more than once"},
+		{"add station monument 8 8", "", "already occupied"},
+		{"connect bank victoria", "", ""},
+		{"connect bank nowhere", "", "station nowhere does not exist"},
+		{"close euston-st_pancras", "", ""},
+		{"list", "bank,8,8\neuston,11,23\nst_pancras,5,15\nvictoria,6,7\nwaterloo,3,1\n" +
+			"bank-victoria\neuston-waterloo\nst_pancras-victoria\nvictoria-waterloo\n", ""},
+		{"close bank", "", "usage: close <a>-<b>"},
+		{"teleport", "", "unknown command teleport"},
+		{"save " + saved, "", ""},
+	} {
+		var out strings.Builder
+		err := replCommand(network, strings.Fields(step.command), &out)
+		switch {
+		case step.err == "" && err != nil:
+			t.Fatalf("step %d, %s: %s", i, step.command, err)
+		case step.err != "" && (err == nil || !strings.Contains(err.Error(), step.err)):
+			t.Fatalf("step %d, %s: got error %v, want one about %q", i, step.command, err, step.err)
+		case out.String() != step.out:
+			t.Fatalf("step %d, %s: printed %q, want %q", i, step.command, out.String(), step.out)
+		}
+	}
+
+	text, err := os.ReadFile(saved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(text), "\n# closed: euston-st_pancras\n") {
+		t.Fatalf("the closed track is not kept as a comment:\n%s", text)
+	}
+	reloaded, err := loadNetwork(saved, ParseConfig{})
+	if err != nil {
+		t.Fatalf("saved map does not parse: %s\n%s", err, text)
+	}
+	if !reflect.DeepEqual(reloaded.Stations, network.Stations) {
+		t.Errorf("stations after saving %v, want %v", reloaded.Stations, network.Stations)
+	}
+	// The closed track is a comment, so it is not a track once read back.
+	if got, want := reloaded.Tracks(), network.Tracks(); !reflect.DeepEqual(got, want) {
+		t.Errorf("tracks after saving %v, want %v", got, want)
+	}
+}
+
+func TestSaveRoundTrips(t *testing.T) {
+	for _, text := range []string{
+		watchMap,
+		watchMap + "lines:\nred,red,waterloo,victoria,st_pancras\nnight,ff8800,st_pancras,euston\n",
+		"coordinates: geo\ntolerance: 25\nstations:\nwaterloo,51.5031,-0.1132\nst_pancras,51.5308,-0.1238\nconnections:\nwaterloo-st_pancras\n",
+	} {
+		data := parseMap(strings.NewReader(text), ParseConfig{})
+		if len(data.Errors) > 0 {
+			t.Fatal(data.Errors)
+		}
+		network := newNetwork(data.Stations, data.Connections)
+		network.Tolerance = data.Tolerance
+		network.Lines = data.Lines
+		var saved strings.Builder
+		if err := network.Save(&saved); err != nil {
+			t.Fatal(err)
+		}
+		again := parseMap(strings.NewReader(saved.String()), ParseConfig{})
+		if len(again.Errors) > 0 {
+			t.Fatalf("saved map has errors %v:\n%s", again.Errors, saved.String())
+		}
+		if !reflect.DeepEqual(again.Stations, data.Stations) || !reflect.DeepEqual(newNetwork(again.Stations, again.Connections).Tracks(), network.Tracks()) || again.Tolerance != data.Tolerance {
+			t.Errorf("saving changed the network:\n%s\nsaved as\n%s", text, saved.String())
+		}
+		if len(again.Lines) != len(data.Lines) {
+			t.Errorf("saving kept %d of %d lines:\n%s", len(again.Lines), len(data.Lines), saved.String())
+		}
+		for i := range again.Lines {
+			if l, want := again.Lines[i], data.Lines[i]; l.Name != want.Name || l.Colour != want.Colour || !reflect.DeepEqual(l.Stations, want.Stations) {
+				t.Errorf("line %s saved as %+v", want.Name, l)
+			}
+		}
+	}
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// delayModel is the value of --delay, how long a train that is ready to move
+// on is held up:
+//
+//	geometric:p   every turn the train is held with chance p
+//	fixed:p:n     with chance p the train is held n turns
+//	uniform:p:n   with chance p the train is held 1 to n turns
+//	none          trains are never held
+type delayModel struct {
+	Kind string
+	P    float64
+	N    int
+}
+
+var defaultDelay = delayModel{Kind: "geometric", P: 0.1}
+
+func (m *delayModel) String() string {
+	switch m.Kind {
+	case "geometric":
+		return fmt.Sprintf("geometric:%g", m.P)
+	case "fixed", "uniform":
+		return fmt.Sprintf("%s:%g:%d", m.Kind, m.P, m.N)
+	}
+	return m.Kind
+}
+
+func (m *delayModel) Set(value string) error {
+	parts := strings.Split(value, ":")
+	model := delayModel{Kind: parts[0]}
+	want := map[string]int{"none": 1, "geometric": 2, "fixed": 3, "uniform": 3}[model.Kind]
+	if want == 0 {
+		return fmt.Errorf("unknown delay distribution %s, use geometric:p, fixed:p:turns, uniform:p:turns or none", parts[0])
+	}
+	if len(parts) != want {
+		return fmt.Errorf("delay (%s) should be given as geometric:p, fixed:p:turns, uniform:p:turns or none", value)
+	}
+	if want >= 2 {
+		p, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil || p < 0 || p > 1 || model.Kind == "geometric" && p == 1 {
+			return fmt.Errorf("unable to convert delay chance (%s) to a probability", parts[1])
+		}
+		model.P = p
+	}
+	if want == 3 {
+		n, err := strconv.Atoi(parts[2])
+		if err != nil || n < 1 {
+			return fmt.Errorf("unable to convert delay turns (%s) to a positive integer", parts[2])
+		}
+		model.N = n
+	}
+	*m = model
+	return nil
+}
+
+// draw returns the number of turns a train is held before its next move.
+func (m delayModel) draw(rng *rand.Rand) int {
+	switch m.Kind {
+	case "geometric":
+		n := 0
+		for rng.Float64() < m.P {
+			n++
+		}
+		return n
+	case "fixed":
+		if rng.Float64() < m.P {
+			return m.N
+		}
+	case "uniform":
+		if rng.Float64() < m.P {
+			return 1 + rng.Intn(m.N)
+		}
+	}
+	return 0
+}
+
+// delayRun holds trains up as the delay model says during one simulation and
+// notes which trains were free to move in which turn.
+type delayRun struct {
+	model delayModel
+	rng   *rand.Rand
+	held  map[string][2]int         // train: position the delay was drawn at, turn it ends
+	ready map[int]map[string]string // turn: train free to move, station it waits to enter
+}
+
+func (d *delayRun) hold(t *Train, turn int) bool {
+	h, ok := d.held[t.Name]
+	if !ok || h[0] != t.Pos {
+		h = [2]int{t.Pos, turn + d.model.draw(d.rng)}
+		d.held[t.Name] = h
+	}
+	if turn < h[1] {
+		return true
+	}
+	if d.ready[turn] == nil {
+		d.ready[turn] = make(map[string]string)
+	}
+	d.ready[turn][t.Name] = t.Route[t.Pos+1]
+	return false
+}
+
+// waits counts, per station, the turns trains were free to move but stayed
+// where they were because the station ahead or the track to it was taken.
+func (d *delayRun) waits(turns [][]Move) map[string]int {
+	waits := make(map[string]int)
+	for i, turn := range turns {
+		moved := make(map[string]bool, len(turn))
+		for _, move := range turn {
+			moved[move.Train] = true
+		}
+		for train, station := range d.ready[i+1] {
+			if !moved[train] {
+				waits[station]++
+			}
+		}
+	}
+	return waits
+}
+
+// simulateDelays runs a copy of the planned trains with delays drawn from the
+// model and returns the turns it took, the waits per station and the trains.
+func simulateDelays(planned []*Train, cfg ScheduleConfig, model delayModel, rng *rand.Rand) (int, map[string]int, []*Train, error) {
+	trains := make([]*Train, len(planned))
+	for i, t := range planned {
+		c := *t
+		c.Pos, c.Arrived = 0, 0
+		trains[i] = &c
+	}
+	run := &delayRun{model: model, rng: rng, held: make(map[string][2]int), ready: make(map[int]map[string]string)}
+	cfg.Hold = run.hold
+	turns, err := runSchedule(trains, cfg)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	return len(turns), run.waits(turns), trains, nil
+}
+
+// knockOn is how often trains had to wait to enter a station in the delayed
+// runs, on top of what they wait in the planned schedule.
+type knockOn struct {
+	Station string
+	Turns   int // turns of waiting over all runs
+	Runs    int // runs in which trains waited there
+}
+
+// robustnessReport sums up many delayed runs of one planned schedule.
+type robustnessReport struct {
+	Model     delayModel
+	Seed      int64
+	Planned   int   // turns without delays
+	Target    int   // turns the trains should be done in
+	Turns     []int // turns of every run, sorted
+	Deadlines bool  // some train has a deadline
+	AllMet    int   // runs in which every deadline was met
+	KnockOn   []knockOn
+}
+
+// robustness simulates the planned trains runs times with delays. Run i
+// draws its delays from seed+i, so a report can be reproduced, and a single
+// run looked at again, from the seed.
+func robustness(planned []*Train, cfg ScheduleConfig, model delayModel, runs int, seed int64, target int) (*robustnessReport, error) {
+	plannedTurns, baseline, _, err := simulateDelays(planned, cfg, delayModel{Kind: "none"}, nil)
+	if err != nil {
+		return nil, err
+	}
+	if target == 0 {
+		target = plannedTurns
+	}
+	report := &robustnessReport{Model: model, Seed: seed, Planned: plannedTurns, Target: target}
+	for _, t := range planned {
+		report.Deadlines = report.Deadlines || t.Deadline > 0
+	}
+	knock := make(map[string]*knockOn)
+	for i := 0; i < runs; i++ {
+		turns, waits, trains, err := simulateDelays(planned, cfg, model, rand.New(rand.NewSource(seed+int64(i))))
+		if err != nil {
+			return nil, fmt.Errorf("run %d: %s", i+1, err)
+		}
+		report.Turns = append(report.Turns, turns)
+		met := true
+		for _, t := range trains {
+			if t.Deadline > 0 && t.Arrived > t.Deadline {
+				met = false
+			}
+		}
+		if met {
+			report.AllMet++
+		}
+		for station, n := range waits {
+			if extra := n - baseline[station]; extra > 0 {
+				if knock[station] == nil {
+					knock[station] = &knockOn{Station: station}
+				}
+				knock[station].Turns += extra
+				knock[station].Runs++
+			}
+		}
+	}
+	sort.Ints(report.Turns)
+	for _, k := range knock {
+		report.KnockOn = append(report.KnockOn, *k)
+	}
+	sort.Slice(report.KnockOn, func(a, b int) bool {
+		ka, kb := report.KnockOn[a], report.KnockOn[b]
+		if ka.Turns != kb.Turns {
+			return ka.Turns > kb.Turns
+		}
+		return ka.Station < kb.Station
+	})
+	return report, nil
+}
+
+// percentile returns the turns that the given share of runs finished within.
+func (r *robustnessReport) percentile(share float64) int {
+	i := int(share*float64(len(r.Turns))+0.999999) - 1
+	return r.Turns[min(max(i, 0), len(r.Turns)-1)]
+}
+
+func (r *robustnessReport) Write(w io.Writer) {
+	runs := len(r.Turns)
+	fmt.Fprintf(w, "Robustness over %d runs, delays %s, seed %d\n", runs, r.Model.String(), r.Seed)
+	if runs == 0 {
+		return
+	}
+	total, within := 0, 0
+	counts := make(map[int]int)
+	for _, turns := range r.Turns {
+		total += turns
+		counts[turns]++
+		if turns <= r.Target {
+			within++
+		}
+	}
+	fmt.Fprintf(w, "Planned: %d turns\n", r.Planned)
+	fmt.Fprintf(w, "Turns: %d at least, %.1f on average, %d median, %d for 90%% of runs, %d at most\n",
+		r.Turns[0], float64(total)/float64(runs), r.percentile(0.5), r.percentile(0.9), r.Turns[runs-1])
+	most := 0
+	for _, n := range counts {
+		most = max(most, n)
+	}
+	for turns := r.Turns[0]; turns <= r.Turns[runs-1]; turns++ {
+		fmt.Fprintf(w, "%5d %-40s %5.1f%%\n", turns, strings.Repeat("#", (40*counts[turns]+most-1)/most), 100*float64(counts[turns])/float64(runs))
+	}
+	fmt.Fprintf(w, "Finished within %d turns: %.1f%% of runs\n", r.Target, 100*float64(within)/float64(runs))
+	if r.Deadlines {
+		fmt.Fprintf(w, "All deadlines met: %.1f%% of runs\n", 100*float64(r.AllMet)/float64(runs))
+	}
+	if len(r.KnockOn) == 0 {
+		fmt.Fprintln(w, "No knock-on delays")
+		return
+	}
+	fmt.Fprintln(w, "Knock-on delays, turns trains waited to enter a station behind a held train:")
+	fmt.Fprintf(w, "%-20s %8s %8s\n", "Station", "Turns", "Runs")
+	for i, k := range r.KnockOn {
+		if i == 10 {
+			break
+		}
+		fmt.Fprintf(w, "%-20s %8.2f %7.1f%%\n", k.Station, float64(k.Turns)/float64(runs), 100*float64(k.Runs)/float64(runs))
+	}
+}
+
+func robustnessMain(args []string, opts *options) {
+	if len(args) != 4 {
+		fmt.Fprintf(os.Stderr, "Error: incorrect number of arguments for robustness\n")
+		fmt.Println(Green, " To test a schedule against delays:")
+		fmt.Println("  go run . robustness [--runs <runs>] [--seed <seed>] [--delay <distribution>] [--target <turns>] <path to file containing network map> <start station> <end station> <numeric amount of trains>", Reset)
+		os.Exit(0)
+	}
+	if len(opts.depots) > 0 {
+		fmt.Fprintf(os.Stderr, "Error: robustness is not supported together with depots\n")
+		os.Exit(0)
+	}
+	start, end := args[1], args[2]
+	traincount, err := strconv.Atoi(args[3])
+	if err != nil || traincount < 1 {
+		fmt.Fprintf(os.Stderr, "Error: unable to convert train numbers(%s) to a positive integer\n", args[3])
+		os.Exit(0)
+	}
+	windows, err := loadWindows(opts)
+	trains := startTrains(traincount, start)
+	if err == nil {
+		err = applyWindows(trains, windows)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		os.Exit(0)
+	}
+	stations, connections, lines, valid := Mapreader(append([]string{args[0]}, opts.maps...), start, end, opts.parse)
+	if !valid {
+		fmt.Println(Red, "Please fix listed errors", Reset)
+		return
+	}
+	ctx := context.Background()
+	if opts.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.timeout)
+		defer cancel()
+	}
+	paths, complete, err := planRoutes(ctx, stations, connections, start, end, traincount, nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		os.Exit(0)
+	}
+	if !complete {
+		fmt.Fprintf(os.Stderr, "Note: the route search ran out of time after %s, the schedule may not be optimal\n", opts.timeout)
+	}
+	routes := forwardAll(paths)
+	lineRoutes, err := applyLines(trains, lines, opts.lines, start, end)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		os.Exit(0)
+	}
+	routes = append(routes, lineRoutes...)
+	if opts.cycles > 0 {
+		routes = roundTrips(routes, opts.cycles)
+		opts.schedule.Terminals = []string{end}
+	}
+	order, _, ok := assignRoutes(trains, routes, opts.schedule)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Error: no route for some trains\n")
+		os.Exit(0)
+	}
+	report, err := robustness(order, opts.schedule, opts.delay, opts.runs, opts.seed, opts.target)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		os.Exit(0)
+	}
+	report.Write(os.Stdout)
+}
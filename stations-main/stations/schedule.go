@@ -0,0 +1,330 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Train is a train with its own route, used by the modes where trains do not
+// all start from the same station or are not all ready at once. Route is in
+// travelling order and Route[0] is where the train is parked.
+type Train struct {
+	Name     string
+	Route    []string
+	Pos      int             // index of the train's station in Route
+	Release  int             // first turn the train may leave, 0 when it is ready at once
+	Deadline int             // last turn the train may arrive in, 0 when there is none
+	Priority int             // trains with a higher priority are planned first
+	Depart   int             // turn the planner wants the train to leave in
+	Earliest int             // turn the train would arrive in with the network to itself
+	Arrived  int             // turn the train reached the end of its route
+	Line     string          // line the train runs on, empty for none
+	Tracks   map[string]bool // tracks the train may use, keyed by trackKey; nil for every track
+	Length   int             // blocks the train is long, 0 is one
+}
+
+func (t *Train) Location() string { return t.Route[t.Pos] }
+
+func (t *Train) Done() bool { return t.Pos == len(t.Route)-1 }
+
+// mayUse reports whether every track of the route is open to the train.
+func (t *Train) mayUse(route []string) bool {
+	if t.Tracks == nil {
+		return true
+	}
+	for i := 1; i < len(route); i++ {
+		if !t.Tracks[trackKey(route[i-1], route[i])] {
+			return false
+		}
+	}
+	return true
+}
+
+// blocks lists the stations and tracks, tracks keyed by trackKey, that a train
+// of the given length takes up with its front at route[pos]. A station and a
+// track are a block each: a train of length 1 takes its station, one of length
+// 2 also the track behind it, one of length 3 the station before that too, and
+// so on. Terminals hold any number of trains and a train that reaches one
+// pulls in whole, so nothing at or behind the first terminal is taken.
+func blocks(route []string, pos, length int, terminal map[string]bool) []string {
+	var taken []string
+	for j := 0; j < max(length, 1); j++ {
+		i := pos - (j+1)/2
+		if i < 0 {
+			break
+		}
+		if j%2 == 1 {
+			taken = append(taken, trackKey(route[i], route[i+1]))
+			continue
+		}
+		if terminal[route[i]] {
+			break
+		}
+		taken = append(taken, route[i])
+	}
+	return taken
+}
+
+// blockMap records which train holds every station and track that is taken.
+type blockMap map[string]string
+
+// free reports whether the train can move on from route[from] to route[to]:
+// the blocks it would take are not held by another train, and neither is the
+// track it runs.
+func (m blockMap) free(t *Train, from, to int, terminal map[string]bool) bool {
+	if holder := m[trackKey(t.Route[from], t.Route[to])]; holder != "" && holder != t.Name {
+		return false
+	}
+	for _, b := range blocks(t.Route, to, t.Length, terminal) {
+		if holder := m[b]; holder != "" && holder != t.Name {
+			return false
+		}
+	}
+	return true
+}
+
+// move lets go of the blocks the train held at route[from] and takes those
+// at route[to].
+func (m blockMap) move(t *Train, from, to int, terminal map[string]bool) {
+	for _, b := range blocks(t.Route, from, t.Length, terminal) {
+		if m[b] == t.Name {
+			delete(m, b)
+		}
+	}
+	for _, b := range blocks(t.Route, to, t.Length, terminal) {
+		m[b] = t.Name
+	}
+}
+
+// ScheduleConfig holds the rules shared by the planner and the simulator.
+type ScheduleConfig struct {
+	// Block is --signalling=block: a track carries one train per turn in
+	// either direction, so trains can never swap places on it.
+	Block bool
+	// Terminals are stations in the middle of routes that still hold any
+	// number of trains, such as the far end of a round trip.
+	Terminals []string
+	// Hold, when set, is asked before a train that is free to leave moves on
+	// and keeps it where it is for the turn if it returns true. It stands for
+	// delays the planner did not know about. It may be asked more than once
+	// for the same train in the same turn and has to answer the same.
+	Hold func(t *Train, turn int) bool
+}
+
+// trackUse names the resource a move from a to b takes for one turn. Without
+// block signalling each direction of a track is its own resource.
+func (c ScheduleConfig) trackUse(a, b string) string {
+	if c.Block {
+		return trackKey(a, b)
+	}
+	return a + ">" + b
+}
+
+// terminals lists the stations that hold any number of trains: the ends of
+// every route and the configured turning points.
+func (c ScheduleConfig) terminals(trains []*Train) map[string]bool {
+	terminal := make(map[string]bool)
+	for _, name := range c.Terminals {
+		terminal[name] = true
+	}
+	for _, t := range trains {
+		terminal[t.Route[0]] = true
+		terminal[t.Route[len(t.Route)-1]] = true
+	}
+	return terminal
+}
+
+// runSchedule moves trains along their routes, one station per turn, until all
+// have arrived. Route endpoints hold any number of trains, every other station
+// only one, and a track is used by one train per turn. Long trains take up the
+// blocks behind them as well, which no other train may enter. Trains further along
+// their route move first so the ones behind can follow in the same turn; a
+// train that was blocked gets another go once others have moved on. No train
+// leaves before its release or planned departure turn, and a held train stays
+// where it is, keeping its station from the trains behind it.
+func runSchedule(trains []*Train, cfg ScheduleConfig) ([][]Move, error) {
+	occupied := make(blockMap)
+	terminal := cfg.terminals(trains)
+	for _, t := range trains {
+		occupied.move(t, t.Pos, t.Pos, terminal)
+	}
+
+	var turns [][]Move
+	for {
+		var waiting []*Train
+		for _, t := range trains {
+			if !t.Done() {
+				waiting = append(waiting, t)
+			}
+		}
+		if len(waiting) == 0 {
+			return turns, nil
+		}
+		sort.SliceStable(waiting, func(a, b int) bool { return waiting[a].Pos > waiting[b].Pos })
+
+		var turn []Move
+		turnNo := len(turns) + 1
+		pending := false
+		used := make(map[string]bool)  // tracks already used this turn
+		moved := make(map[string]bool) // trains that already moved this turn
+		for progress := true; progress; {
+			progress = false
+			for _, t := range waiting {
+				if moved[t.Name] {
+					continue
+				}
+				if t.Pos == 0 && (t.Release > turnNo || t.Depart > turnNo) {
+					pending = true
+					continue
+				}
+				if cfg.Hold != nil && cfg.Hold(t, turnNo) {
+					pending = true
+					continue
+				}
+				next := t.Route[t.Pos+1]
+				if !occupied.free(t, t.Pos, t.Pos+1, terminal) {
+					continue
+				}
+				if used[cfg.trackUse(t.Location(), next)] {
+					continue
+				}
+				used[cfg.trackUse(t.Location(), next)] = true
+				occupied.move(t, t.Pos, t.Pos+1, terminal)
+				t.Pos++
+				if t.Done() {
+					t.Arrived = turnNo
+				}
+				moved[t.Name] = true
+				progress = true
+				turn = append(turn, Move{Train: t.Name, Station: next})
+			}
+		}
+		if len(turn) == 0 && !pending {
+			return turns, fmt.Errorf("trains are stuck after %d turns", len(turns))
+		}
+		turns = append(turns, turn)
+	}
+}
+
+// reservations records which stations and tracks planned trains take in
+// which turn.
+type reservations struct {
+	cfg      ScheduleConfig
+	terminal map[string]bool
+	held     map[string]map[int]bool // station or track is held at the end of the turn
+	tracks   map[string]map[int]bool
+	ran      map[string]map[int]bool // a train ran the track, keyed by trackKey
+}
+
+func newReservations(cfg ScheduleConfig, terminal map[string]bool) *reservations {
+	return &reservations{cfg: cfg, terminal: terminal, held: make(map[string]map[int]bool),
+		tracks: make(map[string]map[int]bool), ran: make(map[string]map[int]bool)}
+}
+
+// fits reports whether a train of the given length leaving route[0] in turn
+// depart and moving on every turn would meet no other planned train: not on
+// the track it runs, not in the blocks it takes, and not with the front of
+// another train running a track it takes.
+func (r *reservations) fits(route []string, depart, length int) bool {
+	for k := 0; k+1 < len(route); k++ {
+		turn := depart + k
+		if r.tracks[r.cfg.trackUse(route[k], route[k+1])][turn] || r.held[trackKey(route[k], route[k+1])][turn] {
+			return false
+		}
+		for _, b := range blocks(route, k+1, length, r.terminal) {
+			if r.held[b][turn] || r.ran[b][turn] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (r *reservations) reserve(route []string, depart, length int) {
+	mark := func(m map[string]map[int]bool, key string, turn int) {
+		if m[key] == nil {
+			m[key] = make(map[int]bool)
+		}
+		m[key][turn] = true
+	}
+	for k := 0; k+1 < len(route); k++ {
+		mark(r.tracks, r.cfg.trackUse(route[k], route[k+1]), depart+k)
+		mark(r.ran, trackKey(route[k], route[k+1]), depart+k)
+		for _, b := range blocks(route, k+1, length, r.terminal) {
+			mark(r.held, b, depart+k)
+		}
+	}
+}
+
+// earliest finds the first turn from which a train can run the whole route
+// without stopping.
+func (r *reservations) earliest(route []string, release, length int) int {
+	depart := 1
+	if release > depart {
+		depart = release
+	}
+	for !r.fits(route, depart, length) {
+		depart++
+	}
+	return depart
+}
+
+// assignRoutes gives every train the route out of its station (Route[0]) on
+// which it would arrive first. Trains already placed are reserved turn by
+// turn, so a train is only sent when its whole route is clear of them, and
+// later trains wait or take a longer route instead. Trains with the highest
+// priority are placed first, then those with the earliest deadline, then those
+// released first. Trains on a line only get routes on its tracks. It returns
+// the trains in the order they were placed, which is the order runSchedule
+// and runEvents have to get them in, and the turn the last train is expected
+// to arrive, or false if some train has no route at all. The trains slice
+// itself is left in its order.
+func assignRoutes(trains []*Train, routes [][]string, cfg ScheduleConfig) ([]*Train, int, bool) {
+	order := append([]*Train(nil), trains...)
+	sort.SliceStable(order, func(a, b int) bool {
+		if order[a].Priority != order[b].Priority {
+			return order[a].Priority > order[b].Priority
+		}
+		da, db := order[a].Deadline, order[b].Deadline
+		if (da == 0) != (db == 0) {
+			return da != 0
+		}
+		if da != db {
+			return da < db
+		}
+		return order[a].Release < order[b].Release
+	})
+	terminal := cfg.terminals(trains)
+	for _, route := range routes {
+		terminal[route[len(route)-1]] = true
+	}
+	booked := newReservations(cfg, terminal)
+	last := 0
+	for _, t := range order {
+		pick, pickArrival, pickDepart := -1, 0, 0
+		t.Earliest = 0
+		for i, route := range routes {
+			if route[0] != t.Route[0] || !t.mayUse(route) {
+				continue
+			}
+			if alone := max(t.Release, 1) + len(route) - 2; t.Earliest == 0 || alone < t.Earliest {
+				t.Earliest = alone
+			}
+			depart := booked.earliest(route, t.Release, t.Length)
+			if arrival := depart + len(route) - 2; pick == -1 || arrival < pickArrival {
+				pick, pickArrival, pickDepart = i, arrival, depart
+			}
+		}
+		if pick == -1 {
+			return nil, 0, false
+		}
+		t.Route = routes[pick]
+		t.Pos = 0
+		t.Depart = pickDepart
+		booked.reserve(t.Route, pickDepart, t.Length)
+		if pickArrival > last {
+			last = pickArrival
+		}
+	}
+	return order, last, true
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Planner chooses the routes the trains take from start to end. Plan offers
+// every usable route set it comes across, best last, with paths in Dijkstra
+// order, end station first. It must return soon after ctx ends.
+type Planner interface {
+	Name() string
+	Plan(ctx context.Context, stations map[string]Station, connections map[string][]string, start, end string, traincount int, offer func([][]string), trace *Explainer)
+}
+
+// greedyPlanner is the original planner: Dijkstra finds the shortest path,
+// pathPlanner removes it and looks for the next, and findConflicts sends it
+// round again while routes share stations.
+type greedyPlanner struct{}
+
+func (greedyPlanner) Name() string { return "greedy" }
+
+func (greedyPlanner) Plan(ctx context.Context, stations map[string]Station, connections map[string][]string, start, end string, _ int, offer func([][]string), trace *Explainer) {
+	replanRoutes(ctx, stations, connections, start, end, offer, trace)
+}
+
+// flowPlanner is exact for routes that share no stations: a min-cost flow
+// adds one route at a time, always the one that keeps the total length
+// lowest, and the number of routes that needs the fewest turns is kept.
+type flowPlanner struct{}
+
+func (flowPlanner) Name() string { return "flow" }
+
+func (flowPlanner) Plan(ctx context.Context, stations map[string]Station, connections map[string][]string, start, end string, traincount int, offer func([][]string), trace *Explainer) {
+	flowRoutes(ctx, stations, connections, start, end, traincount, offer, trace)
+}
+
+// largeMapPlanner is a heuristic for maps too large for the others: it takes
+// the shortest path by breadth-first search, closes its stations and repeats
+// while another route still saves turns. It touches every station and track
+// at most once per route and copies nothing.
+type largeMapPlanner struct{}
+
+func (largeMapPlanner) Name() string { return "large" }
+
+func (largeMapPlanner) Plan(ctx context.Context, _ map[string]Station, connections map[string][]string, start, end string, traincount int, offer func([][]string), trace *Explainer) {
+	used := make(map[string]bool)
+	var routes [][]string
+	bestTurns := -1
+	for len(routes) == 0 || ctx.Err() == nil {
+		path := shortestHops(connections, start, end, used)
+		if path == nil {
+			return
+		}
+		routes = append(routes, path)
+		turns := estimateTurns(routes, traincount)
+		if bestTurns != -1 && turns >= bestTurns {
+			return
+		}
+		bestTurns = turns
+		trace.note("route", map[string]interface{}{"planner": "large", "route": forward(path)},
+			"large: route %d is %s", len(routes), strings.Join(forward(path), " -> "))
+		offer(append([][]string(nil), routes...))
+		for _, station := range path[1 : len(path)-1] {
+			used[station] = true
+		}
+		if len(path) == 2 {
+			// A direct track can carry every train; there is nothing to add.
+			return
+		}
+	}
+}
+
+// shortestHops finds the path with the fewest tracks from start to end that
+// avoids the closed stations, in Dijkstra order, or nil when there is none.
+// Neighbours are visited in name order, so ties always go the same way.
+func shortestHops(connections map[string][]string, start, end string, closed map[string]bool) []string {
+	prev := map[string]string{start: ""}
+	queue := []string{start}
+	for len(queue) > 0 {
+		at := queue[0]
+		queue = queue[1:]
+		neighbours := append([]string(nil), connections[at]...)
+		sort.Strings(neighbours)
+		for _, next := range neighbours {
+			if _, seen := prev[next]; seen || closed[next] || next == start {
+				continue
+			}
+			prev[next] = at
+			if next == end {
+				path := []string{end}
+				for station := at; station != ""; station = prev[station] {
+					path = append(path, station)
+				}
+				return path
+			}
+			queue = append(queue, next)
+		}
+	}
+	return nil
+}
+
+// planners are every strategy there is, in the order compare lists them.
+var planners = []Planner{greedyPlanner{}, flowPlanner{}, largeMapPlanner{}}
+
+// largeMap is the number of stations from which planRoutes leaves the
+// network to the large map heuristic.
+const largeMap = 5000
+
+// routePlanners are the planners planRoutes tries side by side for a map of
+// the given size. On equal turns the earlier one wins, so the greedy planner
+// keeps the routes it has always chosen.
+func routePlanners(stations int) []Planner {
+	if stations >= largeMap {
+		return []Planner{largeMapPlanner{}}
+	}
+	return []Planner{greedyPlanner{}, flowPlanner{}}
+}
+
+// routeResult is the latest route set offered by one strategy.
+type routeResult struct {
+	paths [][]string
+	turns int
+	done  bool
+}
+
+// planRoutes runs the planners in goroutines of their own and returns the route
+// set that moves traincount trains in the fewest turns. Paths are in Dijkstra
+// order, end station first. When ctx ends before every planner has finished,
+// the best set found so far is returned and complete is false.
+//
+// Every planner records its decisions in an Explainer of its own, which are
+// added to trace in planner order once they are all done.
+func planRoutes(ctx context.Context, stations map[string]Station, connections map[string][]string, start, end string, traincount int, trace *Explainer) (paths [][]string, complete bool, err error) {
+	if start == end {
+		return nil, false, fmt.Errorf("Start and end stations are same (%s)", start)
+	}
+	if !reachable(stations, connections, start, end) {
+		trace.note("unreachable", map[string]interface{}{"start": start, "end": end},
+			"%s cannot be reached from %s, no planner was run", end, start)
+		return nil, false, fmt.Errorf("no valid path between %s and %s", start, end)
+	}
+
+	strategies := routePlanners(len(stations))
+	var mu sync.Mutex
+	results := make([]routeResult, len(strategies))
+	traces := make([]*Explainer, len(strategies))
+	var wg sync.WaitGroup
+	for i, strategy := range strategies {
+		if trace != nil {
+			traces[i] = &Explainer{}
+		}
+		wg.Add(1)
+		go func(i int, strategy Planner) {
+			defer wg.Done()
+			strategy.Plan(ctx, stations, connections, start, end, traincount, func(paths [][]string) {
+				mu.Lock()
+				results[i].paths = paths
+				mu.Unlock()
+			}, traces[i])
+			mu.Lock()
+			results[i].done = ctx.Err() == nil
+			mu.Unlock()
+		}(i, strategy)
+	}
+	// Planners check ctx between steps, so waiting for them is short once
+	// the time is up.
+	wg.Wait()
+	for _, t := range traces {
+		trace.merge(t)
+	}
+
+	// The estimate ignores trains held back at the start and routes sharing
+	// stations, so when there is a choice the route sets are run through
+	// runSchedule and ranked by the turns they really take.
+	found := 0
+	for _, result := range results {
+		if result.paths != nil {
+			found++
+		}
+	}
+	best := -1
+	complete = true
+	for i := range results {
+		result := &results[i]
+		complete = complete && result.done
+		if result.paths == nil {
+			continue
+		}
+		if found > 1 {
+			result.turns = scheduledTurns(result.paths, start, traincount)
+		} else {
+			result.turns = estimateTurns(result.paths, traincount)
+		}
+		trace.note("strategy", map[string]interface{}{"strategy": strategies[i].Name(), "routes": forwardAll(result.paths), "turns": result.turns},
+			"%s: %d route(s), %d turns", strategies[i].Name(), len(result.paths), result.turns)
+		if best == -1 || result.turns < results[best].turns {
+			best = i
+		}
+	}
+	if best == -1 {
+		return nil, false, fmt.Errorf("no valid path between %s and %s", start, end)
+	}
+	return results[best].paths, complete, nil
+}
+
+// scheduledTurns is the number of turns runSchedule takes to move traincount
+// trains from start over the paths.
+func scheduledTurns(paths [][]string, start string, traincount int) int {
+	if traincount < 1 {
+		return 0
+	}
+	order, _, ok := assignRoutes(startTrains(traincount, start), forwardAll(paths), ScheduleConfig{})
+	if !ok {
+		return int(^uint(0) >> 1)
+	}
+	turns, err := runSchedule(order, ScheduleConfig{})
+	if err != nil {
+		return int(^uint(0) >> 1)
+	}
+	return len(turns)
+}
+
+// flowRoutes offers the route sets of the min-cost flow, one more route at a
+// time, keeping the set that needs the fewest turns. The first route is always
+// found so there is something to offer.
+func flowRoutes(ctx context.Context, stations map[string]Station, connections map[string][]string, start, end string, traincount int, offer func([][]string), _ *Explainer) {
+	f := newRouteFlow(stations, connections, map[string]int{start: len(stations)}, end)
+	bestTurns := -1
+	for k := 0; (k == 0 || ctx.Err() == nil) && f.augment(); k++ {
+		routes := forwardAll(f.routes()) // back to Dijkstra order
+		if turns := estimateTurns(routes, traincount); bestTurns == -1 || turns < bestTurns {
+			bestTurns = turns
+			offer(routes)
+		}
+	}
+}
+
+// conflictFree keeps the paths that share no station other than start and end
+// with a path before them.
+func conflictFree(paths [][]string) [][]string {
+	var kept [][]string
+	used := make(map[string]bool)
+	for _, path := range paths {
+		clear := true
+		for _, station := range path[1 : len(path)-1] {
+			if used[station] {
+				clear = false
+			}
+		}
+		if !clear {
+			continue
+		}
+		for _, station := range path[1 : len(path)-1] {
+			used[station] = true
+		}
+		kept = append(kept, path)
+	}
+	return kept
+}
+
+// estimateTurns is the number of turns needed to move traincount trains over
+// paths that share no stations, when every path sends one train per turn.
+func estimateTurns(paths [][]string, traincount int) int {
+	if len(paths) == 0 {
+		return int(^uint(0) >> 1)
+	}
+	for turns := 0; ; turns++ {
+		moved := 0
+		for _, path := range paths {
+			if n := turns - (len(path) - 1) + 1; n > 0 {
+				moved += n
+			}
+		}
+		if moved >= traincount {
+			return turns
+		}
+	}
+}
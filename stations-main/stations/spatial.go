@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+)
+
+// SpatialIndex answers questions about where stations are. It is a k-d tree
+// that splits on x and y in turn, so lookups do not have to visit every
+// station of a large network. Geo stations are placed by longitude (x) and
+// latitude (y); Nearest and InMetres measure them in metres over the earth's
+// surface, the other lookups in degrees.
+type SpatialIndex struct {
+	root *kdNode
+	size int
+}
+
+type kdNode struct {
+	station     Station
+	left, right *kdNode
+	axis        int // 0 splits on X, 1 on Y
+}
+
+func coord(st Station, axis int) float64 {
+	x, y := st.Position()
+	if axis == 0 {
+		return x
+	}
+	return y
+}
+
+func newSpatialIndex(stations map[string]Station) *SpatialIndex {
+	list := make([]Station, 0, len(stations))
+	for name, st := range stations {
+		st.Name = name
+		list = append(list, st)
+	}
+	// Sorting first keeps the tree, and with it ties, the same on every run.
+	sort.Slice(list, func(a, b int) bool { return list[a].Name < list[b].Name })
+	return &SpatialIndex{root: buildKD(list, 0), size: len(list)}
+}
+
+func buildKD(list []Station, axis int) *kdNode {
+	if len(list) == 0 {
+		return nil
+	}
+	sort.SliceStable(list, func(a, b int) bool { return coord(list[a], axis) < coord(list[b], axis) })
+	mid := len(list) / 2
+	return &kdNode{
+		station: list[mid],
+		axis:    axis,
+		left:    buildKD(list[:mid], 1-axis),
+		right:   buildKD(list[mid+1:], 1-axis),
+	}
+}
+
+func pointDistance(st Station, x, y float64) float64 {
+	sx, sy := st.Position()
+	return math.Hypot(sx-x, sy-y)
+}
+
+// Nearest returns the station closest to the point, for geo stations the one
+// the fewest metres away from longitude x and latitude y. Of stations at the
+// same distance the one with the first name wins. It returns false for an
+// empty network.
+func (idx *SpatialIndex) Nearest(x, y float64) (Station, bool) {
+	if idx.root == nil {
+		return Station{}, false
+	}
+	distance := func(st Station) float64 {
+		if st.Geo {
+			return haversine(y, x, st.Lat, st.Lon)
+		}
+		return pointDistance(st, x, y)
+	}
+	best := idx.root.station
+	bestDist := distance(best)
+	var search func(n *kdNode)
+	search = func(n *kdNode) {
+		if n == nil {
+			return
+		}
+		if d := distance(n.station); d < bestDist || d == bestDist && n.station.Name < best.Name {
+			best, bestDist = n.station, d
+		}
+		diff := []float64{x, y}[n.axis] - coord(n.station, n.axis)
+		near, far := n.left, n.right
+		if diff > 0 {
+			near, far = far, near
+		}
+		search(near)
+		// The other side can only hold something closer if the splitting
+		// line is within reach.
+		gap, reach := math.Abs(diff), bestDist
+		if n.station.Geo {
+			dLat, dLon := geoReach(y, bestDist)
+			reach = dLat
+			if n.axis == 0 {
+				// Longitudes wrap around at 180, so the other side may
+				// also be reached the other way round the earth.
+				reach = dLon
+				if diff > 0 {
+					gap = math.Min(diff, 180-x)
+				} else {
+					gap = math.Min(-diff, x+180)
+				}
+			}
+		}
+		if gap <= reach {
+			search(far)
+		}
+	}
+	search(idx.root)
+	return best, true
+}
+
+// InBox returns the stations with minX <= X <= maxX and minY <= Y <= maxY,
+// sorted by name.
+func (idx *SpatialIndex) InBox(minX, minY, maxX, maxY float64) []Station {
+	var found []Station
+	low, high := []float64{minX, minY}, []float64{maxX, maxY}
+	var search func(n *kdNode)
+	search = func(n *kdNode) {
+		if n == nil {
+			return
+		}
+		x, y := n.station.Position()
+		if x >= minX && x <= maxX && y >= minY && y <= maxY {
+			found = append(found, n.station)
+		}
+		c := coord(n.station, n.axis)
+		if low[n.axis] <= c {
+			search(n.left)
+		}
+		if high[n.axis] >= c {
+			search(n.right)
+		}
+	}
+	search(idx.root)
+	sort.Slice(found, func(a, b int) bool { return found[a].Name < found[b].Name })
+	return found
+}
+
+// InRadius returns the stations at most r away from the point, closest first.
+func (idx *SpatialIndex) InRadius(x, y, r float64) []Station {
+	var found []Station
+	for _, st := range idx.InBox(x-r, y-r, x+r, y+r) {
+		if pointDistance(st, x, y) <= r {
+			found = append(found, st)
+		}
+	}
+	sort.SliceStable(found, func(a, b int) bool { return pointDistance(found[a], x, y) < pointDistance(found[b], x, y) })
+	return found
+}
+
+// InMetres returns the geo stations at most r metres from a latitude and
+// longitude, closest first.
+func (idx *SpatialIndex) InMetres(lat, lon, r float64) []Station {
+	dLat, dLon := geoReach(lat, r)
+	// Longitudes wrap around at 180, so a circle across the date line is
+	// looked up as a box on either side of it.
+	boxes := [][2]float64{{lon - dLon, lon + dLon}}
+	switch {
+	case dLon >= 180:
+		boxes = [][2]float64{{-180, 180}}
+	case lon-dLon < -180:
+		boxes = [][2]float64{{-180, lon + dLon}, {lon - dLon + 360, 180}}
+	case lon+dLon > 180:
+		boxes = [][2]float64{{lon - dLon, 180}, {-180, lon + dLon - 360}}
+	}
+	var found []Station
+	for _, box := range boxes {
+		for _, st := range idx.InBox(box[0], lat-dLat, box[1], lat+dLat) {
+			if haversine(lat, lon, st.Lat, st.Lon) <= r {
+				found = append(found, st)
+			}
+		}
+	}
+	sort.SliceStable(found, func(a, b int) bool {
+		return haversine(lat, lon, found[a].Lat, found[a].Lon) < haversine(lat, lon, found[b].Lat, found[b].Lon)
+	})
+	return found
+}
+
+// snapRoute finds the stations nearest to two points, to plan between them.
+func snapRoute(idx *SpatialIndex, x1, y1, x2, y2 float64) (Station, Station, error) {
+	from, ok := idx.Nearest(x1, y1)
+	if !ok {
+		return Station{}, Station{}, fmt.Errorf("the map has no stations")
+	}
+	to, _ := idx.Nearest(x2, y2)
+	if from.Name == to.Name {
+		return Station{}, Station{}, fmt.Errorf("both points are closest to %s", from.Name)
+	}
+	return from, to, nil
+}
+
+const spatialUsage = `  go run . nearest <map> <x> <y>
+  go run . box <map> <min x> <min y> <max x> <max y>
+  go run . radius <map> <x> <y> <radius>
+  go run . route <map> <x> <y> <x> <y> <numeric amount of trains>`
+
+// spatialArgs is the number of arguments after the map for every spatial
+// command.
+var spatialArgs = map[string]int{"nearest": 2, "box": 4, "radius": 3, "route": 5}
+
+func spatialMain(command string, args []string, cfg ParseConfig) {
+	if len(args) != spatialArgs[command]+1 {
+		fmt.Fprintf(os.Stderr, "Error: incorrect number of arguments for %s\n", command)
+		fmt.Println(Green, " Spatial queries:")
+		fmt.Println(spatialUsage, Reset)
+		os.Exit(0)
+	}
+	network, err := loadNetwork(args[0], cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Println(Red, "Please fix listed errors in", args[0], Reset)
+		os.Exit(0)
+	}
+	numbers := make([]float64, len(args)-1)
+	for i, arg := range args[1:] {
+		if command == "route" && i == 4 {
+			break
+		}
+		if numbers[i], err = strconv.ParseFloat(arg, 64); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: unable to convert coordinate (%s) to a number\n", arg)
+			os.Exit(0)
+		}
+	}
+	if network.Geo() {
+		// Points are given like in the map, latitude first.
+		for i := 0; i+1 < len(numbers); i += 2 {
+			if command == "radius" && i == 2 {
+				break
+			}
+			numbers[i], numbers[i+1] = numbers[i+1], numbers[i]
+		}
+	}
+	idx := newSpatialIndex(network.Stations)
+	printStations := func(found []Station) {
+		if len(found) == 0 {
+			fmt.Println("No stations found")
+		}
+		for _, st := range found {
+			fmt.Printf("%s (%s)\n", st.Name, st.Coordinates())
+		}
+	}
+
+	switch command {
+	case "nearest":
+		st, ok := idx.Nearest(numbers[0], numbers[1])
+		if !ok {
+			printStations(nil)
+			return
+		}
+		if st.Geo {
+			fmt.Printf("%s (%s), %.0f metres away\n", st.Name, st.Coords, haversine(numbers[1], numbers[0], st.Lat, st.Lon))
+		} else {
+			fmt.Printf("%s (%s), %.2f away\n", st.Name, st.Coordinates(), pointDistance(st, numbers[0], numbers[1]))
+		}
+	case "box":
+		printStations(idx.InBox(numbers[0], numbers[1], numbers[2], numbers[3]))
+	case "radius":
+		if network.Geo() {
+			printStations(idx.InMetres(numbers[1], numbers[0], numbers[2]))
+		} else {
+			printStations(idx.InRadius(numbers[0], numbers[1], numbers[2]))
+		}
+	case "route":
+		traincount, err := strconv.Atoi(args[5])
+		if err != nil || traincount < 1 {
+			fmt.Fprintf(os.Stderr, "Error: unable to convert train numbers(%s) to a positive integer\n", args[5])
+			os.Exit(0)
+		}
+		from, to, err := snapRoute(idx, numbers[0], numbers[1], numbers[2], numbers[3])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			os.Exit(0)
+		}
+		fmt.Printf("From %s (%s) to %s (%s)\n", from.Name, from.Coordinates(), to.Name, to.Coordinates())
+		paths, _, err := planRoutes(context.Background(), network.Stations, network.Connections, from.Name, to.Name, traincount, nil)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			os.Exit(0)
+		}
+		Pathbuilder(Trainnames(traincount, from.Name), paths, network.Stations, from.Name, nil)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// The index has to agree with simply looking at every station.
+func TestSpatialIndexMatchesScan(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for round := 0; round < 50; round++ {
+		stations := make(map[string]Station)
+		for i := rng.Intn(200); i >= 0; i-- {
+			name := fmt.Sprint("s", i)
+			stations[name] = Station{Name: name, X: rng.Intn(50), Y: rng.Intn(50)}
+		}
+		idx := newSpatialIndex(stations)
+		for q := 0; q < 20; q++ {
+			x, y := rng.Float64()*60-5, rng.Float64()*60-5
+
+			var want Station
+			for _, st := range stations {
+				d, best := pointDistance(st, x, y), pointDistance(want, x, y)
+				if want.Name == "" || d < best || d == best && st.Name < want.Name {
+					want = st
+				}
+			}
+			if got, _ := idx.Nearest(x, y); got != want {
+				t.Fatalf("nearest to (%.1f,%.1f) is %v, index says %v", x, y, want, got)
+			}
+
+			x2, y2 := x+rng.Float64()*20, y+rng.Float64()*20
+			var inBox []Station
+			for _, st := range stations {
+				if float64(st.X) >= x && float64(st.X) <= x2 && float64(st.Y) >= y && float64(st.Y) <= y2 {
+					inBox = append(inBox, st)
+				}
+			}
+			sort.Slice(inBox, func(a, b int) bool { return inBox[a].Name < inBox[b].Name })
+			if got := idx.InBox(x, y, x2, y2); !reflect.DeepEqual(got, inBox) {
+				t.Fatalf("box (%.1f,%.1f)-(%.1f,%.1f) holds %v, index says %v", x, y, x2, y2, inBox, got)
+			}
+
+			r := rng.Float64() * 15
+			count := 0
+			for _, st := range stations {
+				if pointDistance(st, x, y) <= r {
+					count++
+				}
+			}
+			if got := idx.InRadius(x, y, r); len(got) != count {
+				t.Fatalf("%d stations within %.1f of (%.1f,%.1f), index says %d", count, r, x, y, len(got))
+			}
+		}
+	}
+}
+
+// A degree of longitude is much shorter than a degree of latitude away from
+// the equator, so the nearest geo station has to be found in metres.
+func TestNearestGeoStationIsClosestInMetres(t *testing.T) {
+	data := parseMap(strings.NewReader("coordinates: geo\nstations:\na,60,0.9\nb,60.6,0\nconnections:\na-b\n"), ParseConfig{})
+	if len(data.Errors) > 0 {
+		t.Fatal(data.Errors)
+	}
+	idx := newSpatialIndex(data.Stations)
+	if st, _ := idx.Nearest(0, 60); st.Name != "a" {
+		t.Fatalf("nearest to 60,0 is a, %.0f m away, not %s", haversine(60, 0, 60, 0.9), st.Name)
+	}
+	if found := idx.InMetres(60, 0, 60000); len(found) != 1 || found[0].Name != "a" {
+		t.Fatalf("within 60 km of 60,0: %v", found)
+	}
+}
+
+func TestGeoLookupsMatchScan(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for round := 0; round < 50; round++ {
+		stations := make(map[string]Station)
+		for i := rng.Intn(200); i >= 0; i-- {
+			name := fmt.Sprint("s", i)
+			// Some rounds crowd the stations near a pole or the date line.
+			lat, lon := rng.Float64()*180-90, rng.Float64()*360-180
+			switch round % 3 {
+			case 1:
+				lat = 80 + rng.Float64()*10
+			case 2:
+				lon = 170 + rng.Float64()*20
+				if lon > 180 {
+					lon -= 360
+				}
+			}
+			stations[name] = Station{Name: name, Geo: true, Lat: lat, Lon: lon}
+		}
+		idx := newSpatialIndex(stations)
+		for q := 0; q < 20; q++ {
+			lat, lon := rng.Float64()*180-90, rng.Float64()*360-180
+			if round%3 == 1 {
+				lat = 75 + rng.Float64()*15
+			}
+			var want Station
+			for _, st := range stations {
+				d, best := haversine(lat, lon, st.Lat, st.Lon), haversine(lat, lon, want.Lat, want.Lon)
+				if want.Name == "" || d < best || d == best && st.Name < want.Name {
+					want = st
+				}
+			}
+			if got, _ := idx.Nearest(lon, lat); got != want {
+				t.Fatalf("nearest to %.2f,%.2f is %s, index says %s", lat, lon, want.Name, got.Name)
+			}
+			r := rng.Float64() * 2000000
+			count := 0
+			for _, st := range stations {
+				if haversine(lat, lon, st.Lat, st.Lon) <= r {
+					count++
+				}
+			}
+			if got := idx.InMetres(lat, lon, r); len(got) != count {
+				t.Fatalf("%d stations within %.0f m of %.2f,%.2f, index says %d", count, r, lat, lon, len(got))
+			}
+		}
+	}
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"math"
+	"os"
+	"sort"
+)
+
+// StationUsage is how busy a station was during a run.
+type StationUsage struct {
+	Station  string `json:"station"`
+	Occupied int    `json:"turnsOccupied"` // turns that ended with a train at the station
+	Trains   int    `json:"trains"`        // trains that were at the station at some point
+	Queue    int    `json:"longestQueue"`  // most trains waiting to leave at the end of a turn
+}
+
+// TrackUsage is how busy a track was during a run.
+type TrackUsage struct {
+	Track    string `json:"track"`
+	Occupied int    `json:"turnsOccupied"` // turns in which a train used the track
+	Trains   int    `json:"trains"`        // times a train went over the track
+}
+
+// UsageStats holds the utilisation of every station and track, busiest first.
+type UsageStats struct {
+	Turns    int            `json:"turns"`
+	Stations []StationUsage `json:"stations"`
+	Tracks   []TrackUsage   `json:"tracks"`
+}
+
+// usageStats replays the turns of a run. origins gives the station every train
+// starts from. A train counts as waiting while it is still at its origin and
+// is gone once it has made its last move.
+func usageStats(stations map[string]Station, connections map[string][]string, origins map[string]string, turns [][]Move) UsageStats {
+	lastMove := make(map[string]int)
+	for t, turn := range turns {
+		for _, move := range turn {
+			lastMove[move.Train] = t
+		}
+	}
+	location := make(map[string]string, len(origins))
+	visited := make(map[string]map[string]bool)
+	visit := func(station, train string) {
+		if visited[station] == nil {
+			visited[station] = make(map[string]bool)
+		}
+		visited[station][train] = true
+	}
+	for train, origin := range origins {
+		location[train] = origin
+		visit(origin, train)
+	}
+
+	stationUse := make(map[string]*StationUsage)
+	for name := range stations {
+		stationUse[name] = &StationUsage{Station: name}
+	}
+	trackUse := make(map[string]*TrackUsage)
+	for _, track := range (&Network{Stations: stations, Connections: connections}).Tracks() {
+		trackUse[track] = &TrackUsage{Track: track}
+	}
+
+	for t, turn := range turns {
+		usedTracks := make(map[string]bool)
+		for _, move := range turn {
+			from := location[move.Train]
+			location[move.Train] = move.Station
+			visit(move.Station, move.Train)
+			key := trackKey(from, move.Station)
+			if trackUse[key] == nil {
+				trackUse[key] = &TrackUsage{Track: key}
+			}
+			trackUse[key].Trains++
+			usedTracks[key] = true
+		}
+		for key := range usedTracks {
+			trackUse[key].Occupied++
+		}
+		held := make(map[string]bool)
+		waiting := make(map[string]int)
+		for train, station := range location {
+			if last, moved := lastMove[train]; moved && last <= t {
+				continue // arrived
+			}
+			held[station] = true
+			if station == origins[train] {
+				waiting[station]++
+			}
+		}
+		for station := range held {
+			if stationUse[station] == nil {
+				stationUse[station] = &StationUsage{Station: station}
+			}
+			stationUse[station].Occupied++
+			if waiting[station] > stationUse[station].Queue {
+				stationUse[station].Queue = waiting[station]
+			}
+		}
+	}
+
+	stats := UsageStats{Turns: len(turns)}
+	for name, use := range stationUse {
+		use.Trains = len(visited[name])
+		stats.Stations = append(stats.Stations, *use)
+	}
+	for _, use := range trackUse {
+		stats.Tracks = append(stats.Tracks, *use)
+	}
+	sort.Slice(stats.Stations, func(a, b int) bool {
+		sa, sb := stats.Stations[a], stats.Stations[b]
+		if sa.Occupied != sb.Occupied {
+			return sa.Occupied > sb.Occupied
+		}
+		if sa.Trains != sb.Trains {
+			return sa.Trains > sb.Trains
+		}
+		return sa.Station < sb.Station
+	})
+	sort.Slice(stats.Tracks, func(a, b int) bool {
+		ta, tb := stats.Tracks[a], stats.Tracks[b]
+		if ta.Occupied != tb.Occupied {
+			return ta.Occupied > tb.Occupied
+		}
+		if ta.Trains != tb.Trains {
+			return ta.Trains > tb.Trains
+		}
+		return ta.Track < tb.Track
+	})
+	return stats
+}
+
+// sameOrigin gives every one of n trains the same origin, as in the default
+// mode where all trains start from one station.
+func sameOrigin(n int, start string) map[string]string {
+	origins := make(map[string]string, n)
+	for _, t := range startTrains(n, start) {
+		origins[t.Name] = start
+	}
+	return origins
+}
+
+func trainOrigins(trains []*Train) map[string]string {
+	origins := make(map[string]string, len(trains))
+	for _, t := range trains {
+		origins[t.Name] = t.Route[0]
+	}
+	return origins
+}
+
+func (u UsageStats) WriteTable(w io.Writer) {
+	fmt.Fprintf(w, "Utilisation over %d turns\n", u.Turns)
+	fmt.Fprintf(w, "%-20s %8s %8s %8s\n", "Station", "Occupied", "Trains", "Queue")
+	for _, s := range u.Stations {
+		fmt.Fprintf(w, "%-20s %8d %8d %8d\n", s.Station, s.Occupied, s.Trains, s.Queue)
+	}
+	fmt.Fprintf(w, "%-41s %8s %8s\n", "Track", "Occupied", "Trains")
+	for _, t := range u.Tracks {
+		fmt.Fprintf(w, "%-41s %8d %8d\n", t.Track, t.Occupied, t.Trains)
+	}
+}
+
+func (u UsageStats) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(u)
+}
+
+// WriteSVG draws the network at the station coordinates, colouring stations
+// and tracks from blue (idle) to red (busiest).
+func (u UsageStats) WriteSVG(w io.Writer, stations map[string]Station) {
+	const size, margin = 800.0, 40.0
+	place := func(st Station) (float64, float64) {
+		x, y := st.Position()
+		if st.Geo {
+			y = -y // north up
+		}
+		return x, y
+	}
+	minX, minY, maxX, maxY := math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)
+	for _, st := range stations {
+		x, y := place(st)
+		minX, maxX = min(minX, x), max(maxX, x)
+		minY, maxY = min(minY, y), max(maxY, y)
+	}
+	span := max(maxX-minX, maxY-minY)
+	if span <= 0 {
+		span = 1
+	}
+	scale := size / span
+	pos := func(name string) (float64, float64) {
+		x, y := place(stations[name])
+		return margin + (x-minX)*scale, margin + (y-minY)*scale
+	}
+	busiest := 1
+	for _, s := range u.Stations {
+		busiest = max(busiest, s.Occupied)
+	}
+	for _, t := range u.Tracks {
+		busiest = max(busiest, t.Occupied)
+	}
+	colour := func(n int) string {
+		return fmt.Sprintf("hsl(%d,80%%,45%%)", 240-240*n/busiest)
+	}
+
+	width := (maxX-minX)*scale + 2*margin
+	height := (maxY-minY)*scale + 2*margin
+	fmt.Fprintf(w, "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%.0f\" height=\"%.0f\" font-family=\"sans-serif\" font-size=\"12\">\n", width, height)
+	fmt.Fprintf(w, "<title>Utilisation over %d turns</title>\n", u.Turns)
+	for _, t := range u.Tracks {
+		a, b, ok := cutTrack(t.Track, stations)
+		if !ok {
+			continue
+		}
+		x1, y1 := pos(a)
+		x2, y2 := pos(b)
+		fmt.Fprintf(w, "<line x1=\"%.1f\" y1=\"%.1f\" x2=\"%.1f\" y2=\"%.1f\" stroke=\"%s\" stroke-width=\"%d\"><title>%s: %d turns, %d trains</title></line>\n",
+			x1, y1, x2, y2, colour(t.Occupied), 2+4*t.Occupied/busiest, html.EscapeString(t.Track), t.Occupied, t.Trains)
+	}
+	for _, s := range u.Stations {
+		if _, ok := stations[s.Station]; !ok {
+			continue
+		}
+		x, y := pos(s.Station)
+		fmt.Fprintf(w, "<circle cx=\"%.1f\" cy=\"%.1f\" r=\"8\" fill=\"%s\"><title>%s: %d turns, %d trains, queue %d</title></circle>\n",
+			x, y, colour(s.Occupied), html.EscapeString(s.Station), s.Occupied, s.Trains, s.Queue)
+		fmt.Fprintf(w, "<text x=\"%.1f\" y=\"%.1f\">%s</text>\n", x+10, y-10, html.EscapeString(s.Station))
+	}
+	fmt.Fprintln(w, "</svg>")
+}
+
+// cutTrack splits a track key back into its stations. Station names may hold
+// hyphens themselves, so every split is checked against the stations.
+func cutTrack(track string, stations map[string]Station) (string, string, bool) {
+	for i := 0; i < len(track); i++ {
+		if track[i] != '-' {
+			continue
+		}
+		_, okA := stations[track[:i]]
+		_, okB := stations[track[i+1:]]
+		if okA && okB {
+			return track[:i], track[i+1:], true
+		}
+	}
+	return "", "", false
+}
+
+// statsMode is the value of --stats. Given on its own it selects the table,
+// --stats=json selects JSON.
+type statsMode string
+
+func (m *statsMode) String() string { return string(*m) }
+
+func (m *statsMode) Set(value string) error {
+	switch value {
+	case "true", "table":
+		*m = "table"
+	case "json":
+		*m = "json"
+	case "false":
+		*m = ""
+	default:
+		return fmt.Errorf("unknown stats format %s, use table or json", value)
+	}
+	return nil
+}
+
+func (m *statsMode) IsBoolFlag() bool { return true }
+
+// writeStats prints the utilisation of a run as asked for by --stats and
+// --heatmap.
+func writeStats(opts *options, stations map[string]Station, connections map[string][]string, origins map[string]string, turns [][]Move) {
+	if opts.stats == "" && opts.heatmap == "" {
+		return
+	}
+	stats := usageStats(stations, connections, origins, turns)
+	switch opts.stats {
+	case "table":
+		stats.WriteTable(os.Stdout)
+	case "json":
+		stats.WriteJSON(os.Stdout)
+	}
+	if opts.heatmap != "" {
+		file, err := os.Create(opts.heatmap)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			return
+		}
+		stats.WriteSVG(file, stations)
+		if err := file.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		}
+	}
+}
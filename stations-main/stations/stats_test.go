@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/xml"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// statsTurns moves four trains from waterloo over both london routes. T3 and
+// T4 wait at waterloo through the first turn.
+var statsTurns = [][]Move{
+	{{"T1", "victoria"}, {"T2", "euston"}},
+	{{"T1", "st_pancras"}, {"T2", "st_pancras"}, {"T3", "victoria"}, {"T4", "euston"}},
+	{{"T3", "st_pancras"}, {"T4", "st_pancras"}},
+}
+
+func TestUsageStats(t *testing.T) {
+	data := parseMap(strings.NewReader(watchMap), ParseConfig{})
+	stats := usageStats(data.Stations, data.Connections, sameOrigin(4, "waterloo"), statsTurns)
+	if stats.Turns != 3 {
+		t.Fatalf("got %d turns, want 3", stats.Turns)
+	}
+	stations := make(map[string]StationUsage)
+	for _, s := range stats.Stations {
+		stations[s.Station] = s
+	}
+	for _, want := range []StationUsage{
+		{Station: "waterloo", Occupied: 1, Trains: 4, Queue: 2},
+		{Station: "victoria", Occupied: 2, Trains: 2},
+		{Station: "euston", Occupied: 2, Trains: 2},
+		// Trains that arrived no longer hold the end.
+		{Station: "st_pancras", Occupied: 0, Trains: 4},
+	} {
+		if got := stations[want.Station]; got != want {
+			t.Errorf("%s: got %+v, want %+v", want.Station, got, want)
+		}
+	}
+	if len(stats.Stations) != 4 || stats.Stations[3].Station != "st_pancras" {
+		t.Errorf("stations should be listed busiest first, got %+v", stats.Stations)
+	}
+	want := []TrackUsage{
+		{Track: "euston-st_pancras", Occupied: 2, Trains: 2},
+		{Track: "euston-waterloo", Occupied: 2, Trains: 2},
+		{Track: "st_pancras-victoria", Occupied: 2, Trains: 2},
+		{Track: "victoria-waterloo", Occupied: 2, Trains: 2},
+	}
+	if !reflect.DeepEqual(stats.Tracks, want) {
+		t.Errorf("tracks %+v, want %+v", stats.Tracks, want)
+	}
+}
+
+func TestUsageSVGIsWellFormed(t *testing.T) {
+	data := parseMap(strings.NewReader(watchMap), ParseConfig{})
+	stats := usageStats(data.Stations, data.Connections, sameOrigin(4, "waterloo"), statsTurns)
+	var out strings.Builder
+	stats.WriteSVG(&out, data.Stations)
+
+	decoder := xml.NewDecoder(strings.NewReader(out.String()))
+	var root string
+	circles, lines := 0, 0
+	for {
+		tok, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("SVG is not well-formed: %s\n%s", err, out.String())
+		}
+		if start, ok := tok.(xml.StartElement); ok {
+			if root == "" {
+				root = start.Name.Local
+			}
+			switch start.Name.Local {
+			case "circle":
+				circles++
+			case "line":
+				lines++
+			}
+		}
+	}
+	if root != "svg" || circles != 4 || lines != 4 {
+		t.Fatalf("expected an svg with 4 stations and 4 tracks, got root %q, %d circles and %d lines", root, circles, lines)
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// watchInterval is how often --watch looks at the map files.
+const watchInterval = 500 * time.Millisecond
+
+// fileStamp is what --watch compares to notice that a file was saved.
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+	missing bool
+}
+
+func stampFiles(paths []string) map[string]fileStamp {
+	stamps := make(map[string]fileStamp, len(paths))
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			stamps[path] = fileStamp{missing: true}
+			continue
+		}
+		stamps[path] = fileStamp{modTime: info.ModTime(), size: info.Size()}
+	}
+	return stamps
+}
+
+func sameStamps(a, b map[string]fileStamp) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for path, stamp := range a {
+		if other, ok := b[path]; !ok || !other.modTime.Equal(stamp.modTime) || other.size != stamp.size || other.missing != stamp.missing {
+			return false
+		}
+	}
+	return true
+}
+
+// watcher re-checks a map whenever one of its files changes. It remembers the
+// errors it has already shown so that only new ones are printed. The trains
+// run with the options of a normal run, windows, signalling, lines, round
+// trips and continuous time included.
+type watcher struct {
+	mapfiles   []string
+	start, end string
+	traincount int
+	opts       *options
+	known      map[string]bool // errors already reported
+	fresh      []string        // errors first seen by the last check
+	files      []string        // every file read by the last check, includes and the scenario too
+}
+
+func newWatcher(mapfiles []string, start, end string, traincount int, opts *options) *watcher {
+	return &watcher{mapfiles: mapfiles, start: start, end: end, traincount: traincount, opts: opts,
+		known: make(map[string]bool), files: mapfiles}
+}
+
+// run checks the map once and then again after every change until ctx ends.
+func (wt *watcher) run(ctx context.Context, w io.Writer, interval time.Duration) {
+	stamps := stampFiles(wt.files)
+	wt.check(w)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		current := stampFiles(wt.files)
+		if sameStamps(stamps, current) {
+			continue
+		}
+		wt.check(w)
+		// The files may have changed, includes added or removed.
+		stamps = stampFiles(wt.files)
+	}
+}
+
+// check validates the map, plans the routes and simulates the trains, then
+// prints one summary line followed by errors that were not there before.
+func (wt *watcher) check(w io.Writer) {
+	fmt.Fprintf(w, "[%s] %s: %s\n", time.Now().Format("15:04:05"), strings.Join(wt.mapfiles, ", "), wt.summary())
+	for _, msg := range wt.fresh {
+		fmt.Fprintf(w, "  + %s\n", msg)
+	}
+}
+
+func (wt *watcher) summary() string {
+	wt.fresh = nil
+	var read []string
+	data, err := loadMapFrom(wt.mapfiles, wt.opts.parse, func(path string) ([]byte, error) {
+		read = append(read, path)
+		return os.ReadFile(path)
+	})
+	wt.files = append(append([]string(nil), wt.mapfiles...), read...)
+	if wt.opts.scenario != "" {
+		wt.files = append(wt.files, wt.opts.scenario)
+	}
+	if err != nil {
+		return err.Error()
+	}
+
+	current := make(map[string]bool)
+	for _, e := range data.Errors {
+		// Editing moves errors to other lines, so they are told apart by file
+		// and message only.
+		key := e.File + ": " + e.Msg
+		current[key] = true
+		if !wt.known[key] {
+			wt.fresh = append(wt.fresh, e.Error())
+		}
+	}
+	fixed := 0
+	for key := range wt.known {
+		if !current[key] {
+			fixed++
+		}
+	}
+	wt.known = current
+	if len(data.Errors) > 0 {
+		text := fmt.Sprintf("%d error(s), %d new", len(data.Errors), len(wt.fresh))
+		if fixed > 0 {
+			text += fmt.Sprintf(", %d fixed", fixed)
+		}
+		return text
+	}
+
+	text := fmt.Sprintf("%d stations, %d connections", len(data.Stations), len(data.Tracks))
+	if fixed > 0 {
+		text = fmt.Sprintf("all %d error(s) fixed, %s", fixed, text)
+	}
+	for _, name := range []string{wt.start, wt.end} {
+		if !hasStation(data.Stations, name) {
+			return fmt.Sprintf("%s, station %s not found", text, name)
+		}
+	}
+	ctx := context.Background()
+	if wt.opts.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, wt.opts.timeout)
+		defer cancel()
+	}
+	paths, complete, err := planRoutes(ctx, data.Stations, data.Connections, wt.start, wt.end, wt.traincount, nil)
+	if err != nil {
+		return fmt.Sprintf("%s, %s", text, err)
+	}
+	turns, trains, err := wt.schedule(data, paths)
+	if err != nil {
+		return fmt.Sprintf("%s, %d route(s), %s", text, len(paths), err)
+	}
+	text = fmt.Sprintf("%s, %d route(s), %d turns for %d trains", text, len(paths), len(turns), wt.traincount)
+	missed := 0
+	for _, t := range trains {
+		if t.Deadline > 0 && (t.Arrived == 0 || t.Arrived > t.Deadline) {
+			missed++
+		}
+	}
+	if missed > 0 {
+		text += fmt.Sprintf(", %d deadline(s) missed", missed)
+	}
+	if !complete {
+		text += " (may not be optimal)"
+	}
+	return text
+}
+
+// schedule moves the trains over paths as a normal run with the same options
+// would, and returns the turns and, when the scheduler ran them, the trains.
+// The scenario is read again every time, as it may have changed too.
+func (wt *watcher) schedule(data *MapData, paths [][]string) ([][]Move, []*Train, error) {
+	windows, err := loadWindows(wt.opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !needsScheduler(wt.opts, windows) {
+		return simulate(Trainnames(wt.traincount, wt.start), paths, data.Stations, wt.start, nil), nil, nil
+	}
+	trains, routes, cfg, err := scheduledTrains(wt.opts, windows, paths, data.Lines, wt.traincount, wt.start, wt.end)
+	if err != nil {
+		return nil, nil, err
+	}
+	order, _, ok := assignRoutes(trains, routes, cfg)
+	if !ok {
+		return nil, nil, fmt.Errorf("no route for some trains")
+	}
+	if !wt.opts.continuous {
+		turns, err := runSchedule(order, cfg)
+		return turns, trains, err
+	}
+	timings, err := loadTimings(wt.opts, data.Stations, data.Connections)
+	if err != nil {
+		return nil, nil, err
+	}
+	moves, err := runEvents(order, cfg, timings)
+	return timedTurns(moves), trains, err
+}
+
+func watchMain(args []string, opts *options) {
+	traincount, err := strconv.Atoi(args[4])
+	if err != nil || traincount < 1 {
+		fmt.Fprintf(os.Stderr, "Error: unable to convert train numbers(%s) to a positive integer\n", args[4])
+		os.Exit(0)
+	}
+	if args[2] == args[3] {
+		fmt.Fprintf(os.Stderr, "Error: Start and end stations are same (%s)\n", args[2])
+		os.Exit(0)
+	}
+	if opts.stats != "" || opts.heatmap != "" || opts.explain != "" {
+		fmt.Fprintf(os.Stderr, "Error: --stats, --heatmap and --explain are not supported together with --watch\n")
+		os.Exit(0)
+	}
+	fmt.Println(Green, "Watching for changes, press Ctrl+C to stop", Reset)
+	wt := newWatcher(append([]string{args[1]}, opts.maps...), args[2], args[3], traincount, opts)
+	wt.run(context.Background(), os.Stdout, watchInterval)
+}
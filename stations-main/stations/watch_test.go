@@ -0,0 +1,110 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const watchMap = `stations:
+waterloo,3,1
+victoria,6,7
+euston,11,23
+st_pancras,5,15
+connections:
+waterloo-victoria
+waterloo-euston
+st_pancras-euston
+victoria-st_pancras
+`
+
+func writeFile(t *testing.T, path, text string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(text), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Every check tells new errors from those already shown, however they moved,
+// and counts the ones that went away.
+func TestWatcherTracksErrorsBetweenChecks(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "london.txt")
+	wt := newWatcher([]string{path}, "waterloo", "st_pancras", 4, &options{})
+	for i, step := range []struct {
+		text, summary string
+		fresh         []string
+	}{
+		{watchMap, "4 stations, 4 connections, 2 route(s), 3 turns for 4 trains", nil},
+		{watchMap + "euston-nowhere\n", "1 error(s), 1 new", []string{"nowhere"}},
+		// The same error a line further down is not new.
+		{strings.Replace(watchMap, "connections:\n", "connections:\n\n", 1) + "euston-nowhere\n", "1 error(s), 0 new", nil},
+		{watchMap + "euston-nowhere\nvictoria-elsewhere\n", "2 error(s), 1 new", []string{"elsewhere"}},
+		{watchMap + "victoria-elsewhere\n", "1 error(s), 0 new, 1 fixed", nil},
+		{watchMap, "all 1 error(s) fixed, 4 stations, 4 connections, 2 route(s), 3 turns for 4 trains", nil},
+	} {
+		writeFile(t, path, step.text)
+		if got := wt.summary(); got != step.summary {
+			t.Fatalf("step %d: summary %q, want %q", i, got, step.summary)
+		}
+		if len(wt.fresh) != len(step.fresh) {
+			t.Fatalf("step %d: new errors %q, want ones about %q", i, wt.fresh, step.fresh)
+		}
+		for j, name := range step.fresh {
+			if !strings.Contains(wt.fresh[j], name) {
+				t.Fatalf("step %d: new errors %q, want ones about %q", i, wt.fresh, step.fresh)
+			}
+		}
+	}
+}
+
+// An included file is watched as well, and read again when it changes.
+func TestWatcherRereadsChangedInclude(t *testing.T) {
+	dir := t.TempDir()
+	main := filepath.Join(dir, "main.txt")
+	north := filepath.Join(dir, "north.txt")
+	writeFile(t, main, "include: north.txt\nstations:\nwaterloo,3,1\nvictoria,6,7\nconnections:\nwaterloo-victoria\n")
+	writeFile(t, north, "stations:\nst_pancras,5,15\nconnections:\nvictoria-st_pancras\n")
+
+	wt := newWatcher([]string{main}, "waterloo", "st_pancras", 2, &options{})
+	if got, want := wt.summary(), "3 stations, 2 connections, 1 route(s), 3 turns for 2 trains"; got != want {
+		t.Fatalf("summary %q, want %q", got, want)
+	}
+	found := false
+	for _, file := range wt.files {
+		found = found || filepath.Clean(file) == north
+	}
+	if !found {
+		t.Fatalf("the include is not watched, files %q", wt.files)
+	}
+
+	stamps := stampFiles(wt.files)
+	writeFile(t, north, "stations:\nst_pancras,5,15\neuston,11,23\nconnections:\nvictoria-st_pancras\nwaterloo-euston\neuston-st_pancras\n")
+	if sameStamps(stamps, stampFiles(wt.files)) {
+		t.Fatal("the change to the include went unnoticed")
+	}
+	if got, want := wt.summary(), "4 stations, 4 connections, 2 route(s), 2 turns for 2 trains"; got != want {
+		t.Fatalf("summary after the include changed %q, want %q", got, want)
+	}
+}
+
+// The summary runs the trains as a normal run with the same options would.
+func TestWatcherHonoursRunOptions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "london.txt")
+	writeFile(t, path, watchMap)
+	for _, c := range []struct {
+		name    string
+		opts    *options
+		summary string
+	}{
+		{"deadlines", &options{arriveBy: stringList{"T4:1"}}, "3 turns for 4 trains, 1 deadline(s) missed"},
+		{"dwell", &options{continuous: true, dwell: 0.25}, "4 turns for 4 trains"},
+		{"round trips", &options{cycles: 1}, "5 turns for 4 trains"},
+		{"unknown train", &options{depart: stringList{"T9:2"}}, "2 route(s), train T9 is not one of the 4 trains (T1 to T4)"},
+	} {
+		wt := newWatcher([]string{path}, "waterloo", "st_pancras", 4, c.opts)
+		if got := wt.summary(); !strings.HasSuffix(got, c.summary) {
+			t.Errorf("%s: summary %q, want it to end in %q", c.name, got, c.summary)
+		}
+	}
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// trainWindow is what a scenario says about one train: when it may leave,
+// when it has to arrive, how important it is and how long it is.
+type trainWindow struct {
+	Release  int
+	Deadline int
+	Priority int
+	Length   int
+}
+
+// readScenario parses a scenario file:
+//
+//	trains:
+//	T1,3,10   # may leave in turn 3, has to arrive by turn 10
+//	T2,2      # may leave in turn 2, no deadline
+//	T3,,6     # ready at once, has to arrive by turn 6
+//	T4,,,2    # ready at once, no deadline, priority 2
+//	T5,,,,3   # ready at once, no deadline, 3 blocks long
+func readScenario(r io.Reader, windows map[string]trainWindow) error {
+	scanner := bufio.NewScanner(r)
+	section := ""
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.ReplaceAll(scanner.Text(), " ", "")
+		line, _, _ = strings.Cut(line, "#")
+		if strings.HasSuffix(line, ":") {
+			section = strings.TrimSuffix(line, ":")
+			continue
+		}
+		if line == "" || section != "trains" {
+			continue
+		}
+		parts := strings.Split(line, ",")
+		if len(parts) < 2 || len(parts) > 5 {
+			return fmt.Errorf("line %d: train should be given as name,earliest departure[,latest arrival[,priority[,length]]]", lineNo)
+		}
+		w := windows[parts[0]]
+		var err error
+		if parts[1] != "" {
+			if w.Release, err = parseTurn(parts[1]); err != nil {
+				return fmt.Errorf("line %d: %s", lineNo, err)
+			}
+		}
+		if len(parts) >= 3 && parts[2] != "" {
+			if w.Deadline, err = parseTurn(parts[2]); err != nil {
+				return fmt.Errorf("line %d: %s", lineNo, err)
+			}
+		}
+		if len(parts) >= 4 && parts[3] != "" {
+			if w.Priority, err = parsePriority(parts[3]); err != nil {
+				return fmt.Errorf("line %d: %s", lineNo, err)
+			}
+		}
+		if len(parts) == 5 && parts[4] != "" {
+			if w.Length, err = parseLength(parts[4]); err != nil {
+				return fmt.Errorf("line %d: %s", lineNo, err)
+			}
+		}
+		windows[parts[0]] = w
+	}
+	return scanner.Err()
+}
+
+func parseTurn(value string) (int, error) {
+	turn, err := strconv.Atoi(value)
+	if err != nil || turn < 0 {
+		return 0, fmt.Errorf("unable to convert turn (%s) to a non-negative integer", value)
+	}
+	return turn, nil
+}
+
+func parsePriority(value string) (int, error) {
+	priority, err := strconv.Atoi(value)
+	if err != nil || priority < 0 {
+		return 0, fmt.Errorf("unable to convert priority (%s) to a non-negative integer", value)
+	}
+	return priority, nil
+}
+
+func parseLength(value string) (int, error) {
+	length, err := strconv.Atoi(value)
+	if err != nil || length < 1 {
+		return 0, fmt.Errorf("unable to convert length (%s) to a positive integer", value)
+	}
+	return length, nil
+}
+
+// loadWindows collects the windows from --scenario, then from --depart,
+// --arrive-by, --priority and --length, which win over the file.
+func loadWindows(opts *options) (map[string]trainWindow, error) {
+	windows := make(map[string]trainWindow)
+	if opts.scenario != "" {
+		file, err := os.Open(opts.scenario)
+		if err != nil {
+			return nil, err
+		}
+		err = readScenario(file, windows)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", opts.scenario, err)
+		}
+	}
+	for _, value := range opts.depart {
+		name, turn, err := parseTrainTurn(value)
+		if err != nil {
+			return nil, err
+		}
+		w := windows[name]
+		w.Release = turn
+		windows[name] = w
+	}
+	for _, value := range opts.arriveBy {
+		name, turn, err := parseTrainTurn(value)
+		if err != nil {
+			return nil, err
+		}
+		w := windows[name]
+		w.Deadline = turn
+		windows[name] = w
+	}
+	for _, value := range opts.priority {
+		name, level, ok := strings.Cut(value, ":")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("(%s) should be given as train:priority", value)
+		}
+		priority, err := parsePriority(level)
+		if err != nil {
+			return nil, err
+		}
+		w := windows[name]
+		w.Priority = priority
+		windows[name] = w
+	}
+	for _, value := range opts.length {
+		name, blocks, ok := strings.Cut(value, ":")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("(%s) should be given as train:length", value)
+		}
+		length, err := parseLength(blocks)
+		if err != nil {
+			return nil, err
+		}
+		w := windows[name]
+		w.Length = length
+		windows[name] = w
+	}
+	return windows, nil
+}
+
+func parseTrainTurn(value string) (string, int, error) {
+	name, turn, ok := strings.Cut(value, ":")
+	if !ok || name == "" {
+		return "", 0, fmt.Errorf("(%s) should be given as train:turn", value)
+	}
+	n, err := parseTurn(turn)
+	return name, n, err
+}
+
+// applyWindows copies the windows onto the trains, refusing names that are
+// not trains of this run.
+func applyWindows(trains []*Train, windows map[string]trainWindow) error {
+	byName := make(map[string]*Train, len(trains))
+	for _, t := range trains {
+		byName[t.Name] = t
+	}
+	var names []string
+	for name := range windows {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		t, ok := byName[name]
+		if !ok {
+			return fmt.Errorf("train %s is not one of the %d trains (T1 to T%d)", name, len(trains), len(trains))
+		}
+		t.Release = windows[name].Release
+		t.Deadline = windows[name].Deadline
+		t.Priority = windows[name].Priority
+		t.Length = windows[name].Length
+	}
+	return nil
+}
+
+// startTrains parks n trains at start, named like Trainnames does.
+func startTrains(n int, start string) []*Train {
+	trains := make([]*Train, n)
+	for i := range trains {
+		trains[i] = &Train{Name: "T" + strconv.Itoa(i+1), Route: []string{start}}
+	}
+	return trains
+}
+
+// reportDeadlines prints the trains that arrived too late, in the order given.
+func reportDeadlines(w io.Writer, trains []*Train) {
+	deadlines, missed := 0, 0
+	for _, t := range trains {
+		if t.Deadline == 0 {
+			continue
+		}
+		deadlines++
+		if t.Arrived == 0 {
+			// The run ended with the train stuck on its way.
+			missed++
+			fmt.Fprintf(w, "%s Deadline missed: %s did not arrive, required by turn %d%s\n", Red, t.Name, t.Deadline, Reset)
+		} else if t.Arrived > t.Deadline {
+			missed++
+			fmt.Fprintf(w, "%s Deadline missed: %s arrived in turn %d, required by turn %d%s\n", Red, t.Name, t.Arrived, t.Deadline, Reset)
+		}
+	}
+	if deadlines > 0 && missed == 0 {
+		fmt.Fprintln(w, Green, "All deadlines met", Reset)
+	}
+}
+
+// reportPriorities prints the delay each priority class took on, compared to
+// every train having the network to itself. Trains that did not arrive have
+// no delay to count and are listed after their class instead. It prints
+// nothing when all trains share one priority.
+func reportPriorities(w io.Writer, trains []*Train) {
+	classes := make(map[int][]*Train)
+	for _, t := range trains {
+		classes[t.Priority] = append(classes[t.Priority], t)
+	}
+	if len(classes) < 2 {
+		return
+	}
+	var priorities []int
+	for p := range classes {
+		priorities = append(priorities, p)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(priorities)))
+	for _, p := range priorities {
+		total, worst, arrived := 0, 0, 0
+		var stuck []*Train
+		for _, t := range classes[p] {
+			if t.Arrived == 0 {
+				// The run ended with the train stuck on its way.
+				stuck = append(stuck, t)
+				continue
+			}
+			delay := t.Arrived - t.Earliest
+			total += delay
+			worst = max(worst, delay)
+			arrived++
+		}
+		if arrived == 0 {
+			fmt.Fprintf(w, "Priority %d: %d train(s), none arrived\n", p, len(classes[p]))
+		} else {
+			fmt.Fprintf(w, "Priority %d: %d train(s), %.1f turns of delay on average, %d at most\n",
+				p, len(classes[p]), float64(total)/float64(arrived), worst)
+		}
+		for _, t := range stuck {
+			fmt.Fprintf(w, "%s Priority %d: %s did not arrive%s\n", Red, p, t.Name, Reset)
+		}
+	}
+}
+
+func trainNumber(name string) int {
+	n, _ := strconv.Atoi(strings.TrimPrefix(name, "T"))
+	return n
+}
+
+// needsScheduler reports whether a run needs the scheduler that knows each
+// train and track rather than simulate: departure windows, block signalling,
+// round trips, trains on lines and continuous time all do.
+func needsScheduler(opts *options, windows map[string]trainWindow) bool {
+	return len(windows) > 0 || opts.schedule.Block || opts.cycles > 0 || len(opts.lines) > 0 || opts.continuous
+}
+
+// scheduledTrains parks traincount trains at start with their windows and
+// lines and lists the routes they may take: the planned paths, the routes of
+// the lines and, for round trips, the way back from end. The returned config
+// is opts.schedule with end as a terminal when the trains turn around there.
+func scheduledTrains(opts *options, windows map[string]trainWindow, paths [][]string, lines []MapLine, traincount int, start, end string) ([]*Train, [][]string, ScheduleConfig, error) {
+	cfg := opts.schedule
+	trains := startTrains(traincount, start)
+	if err := applyWindows(trains, windows); err != nil {
+		return nil, nil, cfg, err
+	}
+	lineRoutes, err := applyLines(trains, lines, opts.lines, start, end)
+	if err != nil {
+		return nil, nil, cfg, err
+	}
+	routes := append(forwardAll(paths), lineRoutes...)
+	if opts.cycles > 0 {
+		// Trains turn around at the end and finish back at the start.
+		routes = roundTrips(routes, opts.cycles)
+		cfg.Terminals = []string{end}
+	}
+	return trains, routes, cfg, nil
+}
+
+// runTrains schedules trains that each carry their own rules and prints the
+// turns, in the colours of the lines, followed by the deadline report. It
+// returns the turns it printed.
+func runTrains(trains []*Train, routes [][]string, cfg ScheduleConfig, lines []MapLine) [][]Move {
+	order, _, ok := assignRoutes(trains, routes, cfg)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Error: no route for some trains\n")
+		return nil
+	}
+	turns, err := runSchedule(order, cfg)
+	printTurns(turns, newTurnPainter(lines, trainOrigins(trains), trainLines(trains)))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+	}
+	reportDeadlines(os.Stdout, trains)
+	reportPriorities(os.Stdout, trains)
+	return turns
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadScenario(t *testing.T) {
+	for _, c := range []struct {
+		text string
+		want map[string]trainWindow
+		err  string
+	}{
+		{"trains:\nT1,3,10\nT2,2\n", map[string]trainWindow{"T1": {Release: 3, Deadline: 10}, "T2": {Release: 2}}, ""},
+		{"trains:\nT3,,6   # deadline only\nT4,,,2\nT5,,,,3\n", map[string]trainWindow{"T3": {Deadline: 6}, "T4": {Priority: 2}, "T5": {Length: 3}}, ""},
+		{"tracks:\na-b,2\ntrains:\nT1, 4\n", map[string]trainWindow{"T1": {Release: 4}}, ""},
+		{"T1,3\n", map[string]trainWindow{}, ""}, // outside the trains section
+		{"trains:\nT1\n", nil, "line 2: train should be given as"},
+		{"trains:\nT1,1,2,3,4,5\n", nil, "line 2: train should be given as"},
+		{"trains:\nT1,-1\n", nil, "line 2: unable to convert turn (-1)"},
+		{"trains:\nT1,,x\n", nil, "line 2: unable to convert turn (x)"},
+		{"trains:\nT1,,,-2\n", nil, "line 2: unable to convert priority (-2)"},
+		{"trains:\nT1,,,,0\n", nil, "line 2: unable to convert length (0)"},
+	} {
+		windows := make(map[string]trainWindow)
+		err := readScenario(strings.NewReader(c.text), windows)
+		if c.err != "" {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("%q: got %v, want an error with %q", c.text, err, c.err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(windows, c.want) {
+			t.Errorf("%q: got %v, %v, want %v", c.text, windows, err, c.want)
+		}
+	}
+}
+
+// Flags win over the scenario file, field by field.
+func TestFlagsOverrideScenario(t *testing.T) {
+	scenario := filepath.Join(t.TempDir(), "scenario.txt")
+	if err := os.WriteFile(scenario, []byte("trains:\nT1,3,10\nT2,2,8,1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	_, opts, err := parseCommandLine([]string{"--scenario", scenario, "--depart", "T1:5", "--arrive-by", "T2:6", "--arrive-by", "T3:4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	windows, err := loadWindows(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]trainWindow{
+		"T1": {Release: 5, Deadline: 10},
+		"T2": {Release: 2, Deadline: 6, Priority: 1},
+		"T3": {Deadline: 4},
+	}
+	if !reflect.DeepEqual(windows, want) {
+		t.Fatalf("got %v, want %v", windows, want)
+	}
+	if err := applyWindows(startTrains(2, "a"), windows); err == nil || !strings.Contains(err.Error(), "train T3 is not one of the 2 trains") {
+		t.Fatalf("a window for T3 of 2 trains should be refused, got %v", err)
+	}
+}
+
+func TestReleaseAndDeadlines(t *testing.T) {
+	routes := [][]string{{"waterloo", "victoria", "st_pancras"}, {"waterloo", "euston", "st_pancras"}}
+	trains := startTrains(3, "waterloo")
+	if err := applyWindows(trains, map[string]trainWindow{
+		"T1": {Release: 3, Deadline: 3}, // leaves in turn 3, so arrives in 4
+		"T2": {Deadline: 2},
+		"T3": {Deadline: 9},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	order, _, ok := assignRoutes(trains, routes, ScheduleConfig{})
+	if !ok {
+		t.Fatal("no route for some trains")
+	}
+	turns, err := runSchedule(order, ScheduleConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for n, turn := range turns[:2] {
+		for _, m := range turn {
+			if m.Train == "T1" {
+				t.Fatalf("T1 moved in turn %d before its release in turn 3", n+1)
+			}
+		}
+	}
+	arrived := map[string]int{}
+	for _, train := range trains {
+		arrived[train.Name] = train.Arrived
+	}
+	if want := map[string]int{"T1": 4, "T2": 2, "T3": 2}; !reflect.DeepEqual(arrived, want) {
+		t.Fatalf("trains arrived in turns %v, want %v", arrived, want)
+	}
+}
+
+func TestReportDeadlines(t *testing.T) {
+	for _, c := range []struct {
+		trains []*Train
+		want   []string
+	}{
+		{[]*Train{{Name: "T1"}, {Name: "T2", Arrived: 3}}, nil},
+		{[]*Train{{Name: "T1", Deadline: 4, Arrived: 4}, {Name: "T2", Arrived: 9}}, []string{"All deadlines met"}},
+		{[]*Train{{Name: "T1", Deadline: 4, Arrived: 5}, {Name: "T2", Deadline: 4, Arrived: 2}},
+			[]string{"Deadline missed: T1 arrived in turn 5, required by turn 4"}},
+		{[]*Train{{Name: "T1", Deadline: 4, Arrived: 6}, {Name: "T2", Deadline: 3}},
+			[]string{"Deadline missed: T1 arrived in turn 6, required by turn 4", "Deadline missed: T2 did not arrive, required by turn 3"}},
+	} {
+		var out bytes.Buffer
+		reportDeadlines(&out, c.trains)
+		var got []string
+		for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+			line = strings.NewReplacer(Red, "", Green, "", Reset, "").Replace(line)
+			if line = strings.TrimSpace(line); line != "" {
+				got = append(got, line)
+			}
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("got %q, want %q", got, c.want)
+		}
+	}
+}
+
+// A train stuck on its way has no delay to count; it is named instead of
+// pulling its class's average down.
+func TestReportPrioritiesNamesTrainsThatDidNotArrive(t *testing.T) {
+	trains := []*Train{
+		{Name: "T1", Priority: 2, Earliest: 2, Arrived: 4},
+		{Name: "T2", Priority: 2, Earliest: 2},
+		{Name: "T3", Earliest: 2, Arrived: 3},
+		{Name: "T4", Earliest: 3, Arrived: 4},
+		{Name: "T5", Priority: 1, Earliest: 2},
+	}
+	var out bytes.Buffer
+	reportPriorities(&out, trains)
+	want := "Priority 2: 2 train(s), 2.0 turns of delay on average, 2 at most\n" +
+		Red + " Priority 2: T2 did not arrive" + Reset + "\n" +
+		"Priority 1: 1 train(s), none arrived\n" +
+		Red + " Priority 1: T5 did not arrive" + Reset + "\n" +
+		"Priority 0: 2 train(s), 1.0 turns of delay on average, 1 at most\n"
+	if out.String() != want {
+		t.Fatalf("got\n%swant\n%s", out.String(), want)
+	}
+}
+
+// On london the two routes carry one train each per turn. T4 with the higher
+// priority leaves first; the others wait for it or go round it.
+func TestPriorityTrainsGoFirst(t *testing.T) {
+	data, err := loadMap([]string{"maps/london.txt"}, ParseConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths, _, err := planRoutes(context.Background(), data.Stations, data.Connections, "waterloo", "st_pancras", 4, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trains := startTrains(4, "waterloo")
+	applyWindows(trains, map[string]trainWindow{"T4": {Priority: 2}})
+	order, _, ok := assignRoutes(trains, forwardAll(paths), ScheduleConfig{})
+	if !ok {
+		t.Fatal("no route for some trains")
+	}
+	if _, err := runSchedule(order, ScheduleConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	arrived := map[string]int{}
+	for _, train := range trains {
+		arrived[train.Name] = train.Arrived
+	}
+	if want := map[string]int{"T1": 2, "T2": 3, "T3": 3, "T4": 2}; !reflect.DeepEqual(arrived, want) {
+		t.Fatalf("trains arrived in turns %v, want %v", arrived, want)
+	}
+	var out bytes.Buffer
+	reportPriorities(&out, trains)
+	want := "Priority 2: 1 train(s), 0.0 turns of delay on average, 0 at most\n" +
+		"Priority 0: 3 train(s), 0.7 turns of delay on average, 1 at most\n"
+	if out.String() != want {
+		t.Fatalf("got\n%swant\n%s", out.String(), want)
+	}
+}
+
+// A train that would have to wait behind a more important one takes a longer
+// route instead when that gets it there no later.
+func TestLowerPriorityTrainsDetour(t *testing.T) {
+	short, long := []string{"a", "b", "c"}, []string{"a", "d", "e", "c"}
+	trains := startTrains(3, "a")
+	applyWindows(trains, map[string]trainWindow{"T3": {Priority: 1}})
+	if _, _, ok := assignRoutes(trains, [][]string{short, long}, ScheduleConfig{}); !ok {
+		t.Fatal("no route for some trains")
+	}
+	for _, c := range []struct {
+		train  *Train
+		route  []string
+		depart int
+	}{
+		{trains[2], short, 1},
+		{trains[0], short, 2}, // waits a turn, as the detour arrives no sooner
+		{trains[1], long, 1},  // the short route is booked until its turn 3
+	} {
+		if !reflect.DeepEqual(c.train.Route, c.route) || c.train.Depart != c.depart {
+			t.Errorf("%s takes %v in turn %d, want %v in turn %d", c.train.Name, c.train.Route, c.train.Depart, c.route, c.depart)
+		}
+	}
+}